@@ -39,8 +39,9 @@ func (m Metrics) New() accumulators.Interface {
 func (m Metrics) Update(e *loggregator_v2.Envelope) {
 	entity := m.GetEntity(e, nrpcf.GetPCFAttributes(e))
 
-	attrs := m.CFAppManager.GetAppInstanceAttributes(
-		e.GetSourceId(),
+	cfapp := m.CFAppManager.GetApp(e.GetSourceId())
+	cfapp.MarkContainerMetric()
+	attrs := cfapp.GetInstanceAttributes(
 		m.ConvertSourceInstance(e.GetInstanceId()),
 	)
 
@@ -105,7 +106,7 @@ func (m Metrics) HarvestMetrics(
 	// Get a client for this metric - checking for insert key and account ID info in the application
 	// We will default to what is in the configuration file	if application specific info isn't found
 	client := nrpcf.GetInsertClientForApp(entity)
-	client.EnqueueEvent(metric.Marshal())
+	client.EnqueueEventFrom("container", metric.Marshal())
 
 }
 
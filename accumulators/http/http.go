@@ -9,19 +9,22 @@ import (
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
-	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/insights"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/redact"
 )
 
 // Nrevents extends event.Accumulator for
 // Firehose HttpStartStop Envelope Event Types
 type Nrevents struct {
 	accumulators.Accumulator
+	CFAppManager *cfapps.CFAppManager
+	Redactor     *redact.Redactor
 }
 
 // New satisfies event.Accumulator
@@ -30,7 +33,13 @@ func (n Nrevents) New() accumulators.Interface {
 		Accumulator: accumulators.NewAccumulator(
 			"*loggregator_v2.Envelope_Timer",
 		),
+		CFAppManager: cfapps.GetInstance(),
 	}
+	redactor, err := redact.New(i.Config())
+	if err != nil {
+		app.Get().Log.Fatalf("invalid redaction settings: %s", err.Error())
+	}
+	i.Redactor = redactor
 	return i
 }
 
@@ -48,13 +57,24 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 	if scErr == nil {
 		s.SetAttribute("http.status", sc)
 	}
-	s.SetAttribute("http.uri", n.GetTag(e, "uri"))
+	uri := n.GetTag(e, "uri")
+	userAgent := n.GetTag(e, "user_agent")
+	// Mask personal data and secrets in query strings and user agents.
+	if n.Redactor != nil {
+		var uriCount, uaCount int
+		uri, uriCount = n.Redactor.RedactQuery(uri)
+		userAgent, uaCount = n.Redactor.Redact(userAgent)
+		if uriCount+uaCount > 0 {
+			s.SetAttribute("http.redacted.count", uriCount+uaCount)
+		}
+	}
+	s.SetAttribute("http.uri", uri)
 	s.SetAttribute("http.method", n.GetTag(e, "method"))
 	s.SetAttribute("http.peer.type", n.GetTag(e, "peer_type"))
 	s.SetAttribute("http.start.timestamp", e.GetTimer().GetStart())
 	s.SetAttribute("http.stop.timestamp", e.GetTimer().GetStop())
 	s.SetAttribute("http.remote.address", n.GetTag(e, "remote_address"))
-	s.SetAttribute("http.user.agent", n.GetTag(e, "user_agent"))
+	s.SetAttribute("http.user.agent", userAgent)
 	s.SetAttribute("http.request.id", n.GetTag(e, "request_id"))
 
 	s.SetAttribute(
@@ -64,9 +84,14 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 	s.SetAttribute("agent.subscription", n.Config().GetString("FIREHOSE_ID"))
 
 	s.AppendAll(entity.Attributes())
+	// Requests routed to an app carry the app GUID as source id.
+	if guid := e.GetSourceId(); cfapps.IsGUID(guid) {
+		s.SetAttribute(n.Config().AttributeName(config.EnvAppID), guid)
+		s.AppendAll(n.CFAppManager.GetAppInstanceAttributes(guid, n.ConvertSourceInstance(e.GetInstanceId())))
+	}
 	// Get an insert client and enqueue the event.
-	client := insights.New().Get(app.Get().Config.GetNewRelicConfig())
-	client.EnqueueEvent(s.Marshal())
+	client := nrpcf.GetInsertClientForPlatform(entity)
+	client.EnqueueEventFrom("http", s.Marshal())
 }
 
 // HarvestMetrics (stub for HttpStartStop)...
@@ -83,6 +108,16 @@ func (n Nrevents) GetDuration(
 	return float64(time.Unix(0, e.GetTimer().GetStop()).Sub(time.Unix(0, e.GetTimer().GetStart()))) / float64(time.Millisecond)
 }
 
+// ConvertSourceInstance from a string to int32
+func (n Nrevents) ConvertSourceInstance(
+	i string,
+) int32 {
+	if num, err := strconv.ParseInt(i, 10, 32); err == nil {
+		return int32(num)
+	}
+	return 0
+}
+
 // GetTag ...
 func (n Nrevents) GetTag(
 	e *loggregator_v2.Envelope,
@@ -0,0 +1,24 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import "unicode/utf8"
+
+// SplitMessage into chunks of at most size bytes without splitting
+// multi-byte UTF-8 characters across chunks.
+func SplitMessage(msg []byte, size int) (chunks [][]byte) {
+	for len(msg) > size {
+		cut := size
+		// Back up to the first byte of the character at the cut.
+		for cut > 0 && !utf8.RuneStart(msg[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = size
+		}
+		chunks = append(chunks, msg[:cut])
+		msg = msg[cut:]
+	}
+	return append(chunks, msg)
+}
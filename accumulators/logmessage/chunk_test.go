@@ -0,0 +1,47 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SplitMessage", func() {
+	for _, c := range []struct {
+		name   string
+		msg    string
+		size   int
+		chunks []string
+	}{
+		{"keeps short messages whole", "hello", 8, []string{"hello"}},
+		{"keeps messages of exactly size whole", "12345678", 8, []string{"12345678"}},
+		{"keeps empty messages", "", 8, []string{""}},
+		{"splits at size", "1234567890", 4, []string{"1234", "5678", "90"}},
+		{"backs up to a character boundary", "abcé€", 4, []string{"abc", "é", "€"}},
+		{"splits characters wider than size", "€€", 2, []string{"\xe2\x82", "\xac", "\xe2\x82", "\xac"}},
+	} {
+		c := c
+		It(c.name, func() {
+			chunks := []string{}
+			for _, chunk := range SplitMessage([]byte(c.msg), c.size) {
+				chunks = append(chunks, string(chunk))
+			}
+			Expect(chunks).To(Equal(c.chunks))
+		})
+	}
+
+	It("never exceeds size and loses nothing", func() {
+		msg := strings.Repeat("aé€😀", 1000)
+		chunks := SplitMessage([]byte(msg), 4095)
+		joined := ""
+		for _, chunk := range chunks {
+			Expect(len(chunk)).To(BeNumerically("<=", 4095))
+			joined += string(chunk)
+		}
+		Expect(joined).To(Equal(msg))
+	})
+})
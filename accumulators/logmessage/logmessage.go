@@ -4,18 +4,24 @@
 package logmessage
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/insights"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/redact"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/rules"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/uid"
 )
 
 // Nrevents extends event.Accumulator for
@@ -23,6 +29,9 @@ import (
 type Nrevents struct {
 	accumulators.Accumulator
 	CFAppManager *cfapps.CFAppManager
+	Rules        *rules.Set
+	Throttle     *Throttle
+	Redactor     *redact.Redactor
 }
 
 // New satisfies event.Accumulator
@@ -33,6 +42,20 @@ func (n Nrevents) New() accumulators.Interface {
 		),
 		CFAppManager: cfapps.GetInstance(),
 	}
+	i.Throttle = NewThrottle(i.Config())
+	redactor, err := redact.New(i.Config())
+	if err != nil {
+		app.Get().Log.Fatalf("invalid redaction settings: %s", err.Error())
+	}
+	i.Redactor = redactor
+	r, err := rules.FromConfig(i.Config(), "LOGMESSAGE_RULES")
+	if err != nil {
+		app.Get().Log.Fatalf("invalid LOGMESSAGE_RULES: %s", err.Error())
+	}
+	if r != nil {
+		app.Get().Log.Infof("loaded %d log message rules", r.Length())
+		i.Rules = r
+	}
 	return i
 }
 
@@ -40,6 +63,11 @@ func (n Nrevents) New() accumulators.Interface {
 // func (n Nrevents) Update(e *events.Envelope) {
 func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 
+	// Log metrics may be wanted without forwarding the log messages themselves.
+	if n.Config().GetBool("LOGMESSAGE_EVENTS_ENABLED") == false {
+		return
+	}
+
 	// Check filters first.  Other work can be avoided if filters aren't matched
 	// Include filters will be checked first, then exclude filters
 	// Stop processing this envelope if the include filters are not matched
@@ -52,6 +80,21 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 		return
 	}
 
+	// Stop processing this envelope if the first matching rule drops it
+	if n.Rules != nil && !n.Rules.Keep(n.RuleLookup(e)) {
+		return
+	}
+
+	// Stop processing this envelope if the app is over its log rate limit
+	if n.Throttle != nil && !n.Throttle.Allow(
+		e.GetSourceId(),
+		n.GetTag(e, "source_type"),
+		e.GetLog().Type == loggregator_v2.Log_ERR,
+	) {
+		n.countThrottled(e)
+		return
+	}
+
 	entity := n.GetEntity(e, nrpcf.GetPCFAttributes(e))
 
 	logEntry := attributes.NewAttributes()
@@ -62,14 +105,39 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 	// msgContent := e.GetLogMessage().GetMessage()
 	msgContent := e.GetLog().Payload
 
-	// Mesages over 4K in length will be rejected by the Event API.  Trim the message before sending.
+	// Mask personal data and secrets before the message leaves the nozzle.
+	if n.Redactor != nil {
+		redacted, count := n.Redactor.Redact(string(msgContent))
+		if count > 0 {
+			msgContent = []byte(redacted)
+			logEntry.SetAttribute("log.redacted.count", count)
+		}
+	}
+
+	// Extract structured access log fields from gorouter log lines.
+	keepMessage := true
+	if n.Config().GetBool("LOGMESSAGE_RTR_PARSE") && strings.HasPrefix(n.GetTag(e, "source_type"), "RTR") {
+		if rtr, parsed := ParseRTR(string(msgContent)); parsed {
+			logEntry.AppendAll(rtr)
+			keepMessage = !n.Config().GetBool("LOGMESSAGE_RTR_DROP_RAW")
+		}
+	}
+
+	// Mesages over 4K in length will be rejected by the Event API.  Split or trim the message before sending.
+	chunks := [][]byte{msgContent}
 	if len(msgContent) > 4096 {
-		msgContent = msgContent[0:4095]
-		logEntry.SetAttribute("log.message.truncated", true)
+		chunks = SplitMessage(msgContent, 4095)
+		max := n.Config().GetInt("LOGMESSAGE_CHUNK_MAX")
+		if max < 1 || n.Config().GetString("LOGMESSAGE_OVERSIZE_MODE") != "chunk" {
+			max = 1
+		}
+		if len(chunks) > max {
+			chunks = chunks[:max]
+			logEntry.SetAttribute("log.message.truncated", true)
+		}
 	}
 
 	// Add log message attributes
-	logEntry.SetAttribute("log.message", string(msgContent))
 	logEntry.SetAttribute("log.timestamp", time.Unix(0, e.GetTimestamp()))
 	logEntry.SetAttribute("log.app.id", e.GetSourceId())
 	logEntry.SetAttribute("log.source.type", n.GetTag(e, "source_type"))
@@ -83,14 +151,69 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 
 	logEntry.AppendAll(entity.Attributes())
 	client := nrpcf.GetInsertClientForApp(entity)
-	client.EnqueueEvent(logEntry.Marshal())
+
+	if !keepMessage || len(chunks) == 1 {
+		if keepMessage {
+			logEntry.SetAttribute("log.message", string(chunks[0]))
+		}
+		client.EnqueueEventFrom("logmessage", logEntry.Marshal())
+		return
+	}
+
+	// Chunks share an ID so the message can be reassembled in order.
+	id := uid.New()
+	for i, chunk := range chunks {
+		event := logEntry.Marshal()
+		event["log.message"] = string(chunk)
+		event["log.message.id"] = id.String()
+		event["log.chunk.index"] = i + 1
+		event["log.chunk.count"] = len(chunks)
+		client.EnqueueEventFrom("logmessage", event)
+	}
+}
+
+// countThrottled records a dropped line against the app for PCFLogThrottle events
+func (n Nrevents) countThrottled(e *loggregator_v2.Envelope) {
+	attrs := nrpcf.AgentAttributes()
+	attrs.SetAttribute(n.Config().AttributeName(config.EnvAppID), e.GetSourceId())
+	attrs.AppendAll(n.CFAppManager.GetApp(e.GetSourceId()).GetAttributes())
+	n.GetEntity(e, attrs).
+		NewSample(
+			"log.throttle.dropped",
+			metrics.Types.Delta,
+			"lines",
+			1,
+		).
+		SetAttribute("log.message.type", n.getLogMessageType(e.GetLog())).
+		Done()
 }
 
-// HarvestMetrics - stub for LogMessages, which are all events...
+// Drain overrides Accumulator Drain to forget idle rate limit buckets
+func (n Nrevents) Drain() []*entities.Entity {
+	if n.Throttle != nil {
+		n.Throttle.Prune()
+	}
+	return n.Accumulator.Drain()
+}
+
+// HarvestMetrics - LogMessages are all events, only throttling is reported here
 func (n Nrevents) HarvestMetrics(
 	entity *entities.Entity,
 	metric *metrics.Metric,
 ) {
+
+	metric.SetAttribute(
+		"eventType",
+		n.Config().GetString(config.NewRelicEventTypeLogThrottle),
+	)
+
+	metric.SetAttribute("agent.subscription", n.Config().GetString("FIREHOSE_ID"))
+
+	metric.Attributes().AppendAll(entity.Attributes())
+
+	// Throttling is reported to the default account.
+	client := insights.New().Get(app.Get().Config.GetNewRelicConfig())
+	client.EnqueueEventFrom("logmessage", metric.Marshal())
 }
 
 // IsIncluded ...
@@ -183,6 +306,36 @@ func (n Nrevents) IsIncludedLogMessage(
 	return false
 }
 
+// RuleLookup resolves envelope tags, log fields and application attributes
+// for filter rules. Application attributes are only fetched when a rule needs them.
+func (n Nrevents) RuleLookup(e *loggregator_v2.Envelope) rules.Lookup {
+	var appAttrs *attributes.Attributes
+	return func(name string) (string, bool) {
+		switch name {
+		case "log.message":
+			return string(e.GetLog().Payload), true
+		case "log.message.type":
+			return n.getLogMessageType(e.GetLog()), true
+		case "log.source.type":
+			return n.GetTag(e, "source_type"), true
+		case "source_id", "log.app.id":
+			return e.GetSourceId(), true
+		case "instance_id", "log.source.instance":
+			return e.GetInstanceId(), true
+		}
+		if v, found := e.Tags[name]; found {
+			return v, true
+		}
+		if appAttrs == nil {
+			appAttrs = n.CFAppManager.GetAppInstanceAttributes(e.GetSourceId(), n.ConvertSourceInstance(e.GetInstanceId()))
+		}
+		if attr := appAttrs.Has(name); attr != nil {
+			return fmt.Sprint(attr.Value()), true
+		}
+		return "", false
+	}
+}
+
 // ConvertSourceInstance from a string to int32
 func (n Nrevents) ConvertSourceInstance(
 	i string,
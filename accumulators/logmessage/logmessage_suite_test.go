@@ -0,0 +1,16 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLogMessage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LogMessage Suite")
+}
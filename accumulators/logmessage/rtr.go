@@ -0,0 +1,85 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+)
+
+// rtrAccessLog matches the fixed leading fields of a gorouter access log line
+// host - [timestamp] "method uri protocol" status bytes_received bytes_sent "referer" "user_agent" "remote_address" "backend_address"
+var rtrAccessLog = regexp.MustCompile(
+	`^(\S+) - \[([^\]]+)\] "(\S+) (\S+) ([^"]*)" (\d{3}) (\d+|-) (\d+|-) "([^"]*)" "([^"]*)" "([^"]*)" "([^"]*)"`,
+)
+
+// rtrField matches the trailing key:"value" and key:value fields
+var rtrField = regexp.MustCompile(`(\w+):(?:"([^"]*)"|(\S+))`)
+
+// rtrNumeric fields are reported as numbers rather than strings
+var rtrNumeric = map[string]bool{
+	"response_time": true,
+	"gorouter_time": true,
+	"app_index":     true,
+}
+
+// ParseRTR extracts rtr.* attributes from a gorouter access log line,
+// the bool is false when the line is not an access log line.
+func ParseRTR(line string) (*attributes.Attributes, bool) {
+	m := rtrAccessLog.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+
+	attrs := attributes.NewAttributes()
+	setRTRString(attrs, "rtr.host", m[1])
+	setRTRString(attrs, "rtr.timestamp", m[2])
+	setRTRString(attrs, "rtr.method", m[3])
+	setRTRString(attrs, "rtr.uri", m[4])
+	setRTRString(attrs, "rtr.protocol", m[5])
+	setRTRNumber(attrs, "rtr.status", m[6])
+	setRTRNumber(attrs, "rtr.request.bytes", m[7])
+	setRTRNumber(attrs, "rtr.response.bytes", m[8])
+	setRTRString(attrs, "rtr.referer", m[9])
+	setRTRString(attrs, "rtr.user.agent", m[10])
+	setRTRString(attrs, "rtr.remote.address", m[11])
+	setRTRString(attrs, "rtr.backend.address", m[12])
+
+	for _, f := range rtrField.FindAllStringSubmatch(line[len(m[0]):], -1) {
+		name := "rtr." + f[1]
+		value := f[2]
+		if len(f[3]) > 0 {
+			value = f[3]
+		}
+		if rtrNumeric[f[1]] {
+			setRTRNumber(attrs, name, value)
+			continue
+		}
+		setRTRString(attrs, name, value)
+	}
+
+	return attrs, true
+}
+
+// setRTRString skips values gorouter reports as empty
+func setRTRString(attrs *attributes.Attributes, name string, value string) {
+	if len(value) == 0 || value == "-" {
+		return
+	}
+	attrs.SetAttribute(name, value)
+}
+
+// setRTRNumber skips values that are not numbers
+func setRTRNumber(attrs *attributes.Attributes, name string, value string) {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		attrs.SetAttribute(name, i)
+		return
+	}
+	if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+		attrs.SetAttribute(name, f)
+	}
+}
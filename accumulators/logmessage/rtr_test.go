@@ -0,0 +1,74 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const rtrLine = `app.example.com - [2020-10-19T12:00:00.000+0000] "GET /v1/items?id=1 HTTP/1.1" 200 12 3456 "-" "curl/7.64.1" "10.0.0.1:51234" "10.0.1.5:61001" x_forwarded_for:"203.0.113.7, 10.0.0.1" x_forwarded_proto:"https" vcap_request_id:"5c8c0f4e-1d3a-4a8b-9e1f-2b7d6c4a9f01" response_time:0.012345 gorouter_time:0.000123 app_id:"b8f2e7d4-3c1a-4f5e-8d9b-0a1b2c3d4e5f" app_index:"0" instance_id:"-"`
+
+var _ = Describe("ParseRTR", func() {
+	It("parses the leading fields", func() {
+		attrs, ok := ParseRTR(rtrLine)
+		Expect(ok).To(BeTrue())
+		Expect(attrs.Marshal()).To(SatisfyAll(
+			HaveKeyWithValue("rtr.host", "app.example.com"),
+			HaveKeyWithValue("rtr.timestamp", "2020-10-19T12:00:00.000+0000"),
+			HaveKeyWithValue("rtr.method", "GET"),
+			HaveKeyWithValue("rtr.uri", "/v1/items?id=1"),
+			HaveKeyWithValue("rtr.protocol", "HTTP/1.1"),
+			HaveKeyWithValue("rtr.status", int64(200)),
+			HaveKeyWithValue("rtr.request.bytes", int64(12)),
+			HaveKeyWithValue("rtr.response.bytes", int64(3456)),
+			HaveKeyWithValue("rtr.user.agent", "curl/7.64.1"),
+			HaveKeyWithValue("rtr.remote.address", "10.0.0.1:51234"),
+			HaveKeyWithValue("rtr.backend.address", "10.0.1.5:61001"),
+		))
+	})
+
+	It("parses the trailing fields", func() {
+		attrs, _ := ParseRTR(rtrLine)
+		Expect(attrs.Marshal()).To(SatisfyAll(
+			HaveKeyWithValue("rtr.x_forwarded_for", "203.0.113.7, 10.0.0.1"),
+			HaveKeyWithValue("rtr.x_forwarded_proto", "https"),
+			HaveKeyWithValue("rtr.vcap_request_id", "5c8c0f4e-1d3a-4a8b-9e1f-2b7d6c4a9f01"),
+			HaveKeyWithValue("rtr.response_time", 0.012345),
+			HaveKeyWithValue("rtr.gorouter_time", 0.000123),
+			HaveKeyWithValue("rtr.app_index", int64(0)),
+			HaveKeyWithValue("rtr.app_id", "b8f2e7d4-3c1a-4f5e-8d9b-0a1b2c3d4e5f"),
+		))
+	})
+
+	It("skips empty values", func() {
+		attrs, _ := ParseRTR(rtrLine)
+		Expect(attrs.Marshal()).NotTo(HaveKey("rtr.referer"))
+		Expect(attrs.Marshal()).NotTo(HaveKey("rtr.instance_id"))
+	})
+
+	It("skips byte counts reported as -", func() {
+		attrs, ok := ParseRTR(`app.example.com - [2020-10-19T12:00:00.000+0000] "GET / HTTP/1.1" 499 - - "-" "-" "10.0.0.1:51234" "-"`)
+		Expect(ok).To(BeTrue())
+		Expect(attrs.Marshal()).To(HaveKeyWithValue("rtr.status", int64(499)))
+		Expect(attrs.Marshal()).NotTo(HaveKey("rtr.request.bytes"))
+		Expect(attrs.Marshal()).NotTo(HaveKey("rtr.backend.address"))
+	})
+
+	for _, c := range []struct {
+		name string
+		line string
+	}{
+		{"ignores empty lines", ""},
+		{"ignores app output", "Started GET /v1/items for 10.0.0.1"},
+		{"ignores lines missing fields", `app.example.com - [2020-10-19T12:00:00.000+0000] "GET / HTTP/1.1" 200`},
+	} {
+		c := c
+		It(c.name, func() {
+			attrs, ok := ParseRTR(c.line)
+			Expect(ok).To(BeFalse())
+			Expect(attrs).To(BeNil())
+		})
+	}
+})
@@ -0,0 +1,148 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+)
+
+// limit in lines per second with a burst allowance
+type limit struct {
+	rate  float64
+	burst float64
+}
+
+// bucket is a token bucket for a single app GUID (and source type)
+type bucket struct {
+	limit  limit
+	tokens float64
+	last   time.Time
+}
+
+// Throttle rate limits log lines per app GUID, optionally per source type.
+// A share of each bucket is reserved for ERR lines so they are kept
+// preferentially once an app starts being throttled.
+type Throttle struct {
+	defaults   limit
+	overrides  map[string]limit
+	perSource  bool
+	errReserve float64
+	buckets    map[string]*bucket
+	sync       *sync.Mutex
+}
+
+// NewThrottle from config, returns nil when rate limiting is disabled.
+func NewThrottle(c *config.Config) *Throttle {
+	rate := c.GetFloat64("LOGMESSAGE_RATE_LIMIT")
+	if rate <= 0 {
+		return nil
+	}
+	burst := c.GetFloat64("LOGMESSAGE_RATE_BURST")
+	if burst < rate {
+		burst = rate
+	}
+	t := &Throttle{
+		defaults:   limit{rate, burst},
+		overrides:  map[string]limit{},
+		perSource:  c.GetBool("LOGMESSAGE_RATE_PER_SOURCE"),
+		errReserve: c.GetFloat64("LOGMESSAGE_RATE_ERR_RESERVE"),
+		buckets:    map[string]*bucket{},
+		sync:       &sync.Mutex{},
+	}
+	// Overrides are <app guid>[/<source type>]=<lines per second>[:<burst>]
+	for _, o := range c.GetFilter("LOGMESSAGE_RATE_OVERRIDES") {
+		kv := strings.SplitN(strings.TrimSpace(o), "=", 2)
+		if len(kv) != 2 {
+			app.Get().Log.Warnf("ignoring invalid log rate override: %s", o)
+			continue
+		}
+		l, err := parseLimit(kv[1])
+		if err != nil {
+			app.Get().Log.Warnf("ignoring invalid log rate override: %s", o)
+			continue
+		}
+		t.overrides[kv[0]] = l
+	}
+	return t
+}
+
+func parseLimit(s string) (l limit, err error) {
+	parts := strings.SplitN(s, ":", 2)
+	if l.rate, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return
+	}
+	l.burst = l.rate
+	if len(parts) == 2 {
+		if l.burst, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// limitFor returns the most specific limit for the app and source type
+func (t *Throttle) limitFor(guid string, sourceType string) limit {
+	if l, found := t.overrides[guid+"/"+sourceType]; found && t.perSource {
+		return l
+	}
+	if l, found := t.overrides[guid]; found {
+		return l
+	}
+	return t.defaults
+}
+
+// Allow reports whether a log line can be forwarded. ERR lines may use the
+// reserved share of the bucket, OUT lines may not.
+func (t *Throttle) Allow(guid string, sourceType string, isErr bool) bool {
+	key := guid
+	if t.perSource {
+		key = guid + "/" + sourceType
+	}
+	now := time.Now()
+
+	t.sync.Lock()
+	defer t.sync.Unlock()
+
+	b, found := t.buckets[key]
+	if !found {
+		l := t.limitFor(guid, sourceType)
+		b = &bucket{limit: l, tokens: l.burst, last: now}
+		t.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.rate
+	if b.tokens > b.limit.burst {
+		b.tokens = b.limit.burst
+	}
+	b.last = now
+
+	floor := 0.0
+	if !isErr {
+		floor = math.Min(b.limit.burst*t.errReserve, b.limit.burst-1)
+	}
+	if b.tokens-1 >= floor {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// Prune forgets buckets that have been idle long enough to refill
+func (t *Throttle) Prune() {
+	now := time.Now()
+	t.sync.Lock()
+	defer t.sync.Unlock()
+	for key, b := range t.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.rate >= b.limit.burst {
+			delete(t.buckets, key)
+		}
+	}
+}
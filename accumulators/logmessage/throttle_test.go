@@ -0,0 +1,132 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func newTestThrottle(rate float64, burst float64, errReserve float64, perSource bool) *Throttle {
+	return &Throttle{
+		defaults:   limit{rate, burst},
+		overrides:  map[string]limit{},
+		perSource:  perSource,
+		errReserve: errReserve,
+		buckets:    map[string]*bucket{},
+		sync:       &sync.Mutex{},
+	}
+}
+
+// allowed counts how many of n lines are let through
+func allowed(t *Throttle, guid string, sourceType string, isErr bool, n int) (count int) {
+	for i := 0; i < n; i++ {
+		if t.Allow(guid, sourceType, isErr) {
+			count++
+		}
+	}
+	return
+}
+
+var _ = Describe("Throttle", func() {
+	for _, c := range []struct {
+		name       string
+		burst      float64
+		errReserve float64
+		isErr      bool
+		expected   int
+	}{
+		{"allows the burst of OUT lines without a reserve", 10, 0, false, 10},
+		{"keeps the reserve out of reach of OUT lines", 10, 0.2, false, 8},
+		{"lets ERR lines use the reserve", 10, 0.2, true, 10},
+		{"always allows one OUT line", 1, 1, false, 1},
+	} {
+		c := c
+		It(c.name, func() {
+			t := newTestThrottle(0.001, c.burst, c.errReserve, false)
+			Expect(allowed(t, "app", "APP/PROC/WEB", c.isErr, 20)).To(Equal(c.expected))
+		})
+	}
+
+	It("lets ERR lines through once OUT lines are throttled", func() {
+		t := newTestThrottle(0.001, 10, 0.2, false)
+		Expect(allowed(t, "app", "APP/PROC/WEB", false, 20)).To(Equal(8))
+		Expect(allowed(t, "app", "APP/PROC/WEB", true, 20)).To(Equal(2))
+	})
+
+	It("refills the bucket over time", func() {
+		t := newTestThrottle(10, 1, 0, false)
+		Expect(t.Allow("app", "APP/PROC/WEB", false)).To(BeTrue())
+		Expect(t.Allow("app", "APP/PROC/WEB", false)).To(BeFalse())
+		t.buckets["app"].last = time.Now().Add(-time.Second)
+		Expect(t.Allow("app", "APP/PROC/WEB", false)).To(BeTrue())
+	})
+
+	It("keeps a bucket per app", func() {
+		t := newTestThrottle(0.001, 2, 0, false)
+		Expect(allowed(t, "app1", "APP/PROC/WEB", false, 5)).To(Equal(2))
+		Expect(allowed(t, "app2", "APP/PROC/WEB", false, 5)).To(Equal(2))
+	})
+
+	It("keeps a bucket per source type when enabled", func() {
+		t := newTestThrottle(0.001, 2, 0, true)
+		Expect(allowed(t, "app", "APP/PROC/WEB", false, 5)).To(Equal(2))
+		Expect(allowed(t, "app", "RTR", false, 5)).To(Equal(2))
+	})
+
+	for _, c := range []struct {
+		name       string
+		perSource  bool
+		overrides  map[string]limit
+		sourceType string
+		expected   int
+	}{
+		{"uses the app override", false, map[string]limit{"app": {0.001, 3}}, "RTR", 3},
+		{"uses the source override when per source", true, map[string]limit{"app": {0.001, 3}, "app/RTR": {0.001, 4}}, "RTR", 4},
+		{"ignores the source override otherwise", false, map[string]limit{"app": {0.001, 3}, "app/RTR": {0.001, 4}}, "RTR", 3},
+		{"falls back to the default", true, map[string]limit{"other": {0.001, 3}}, "RTR", 2},
+	} {
+		c := c
+		It(c.name, func() {
+			t := newTestThrottle(0.001, 2, 0, c.perSource)
+			t.overrides = c.overrides
+			Expect(allowed(t, "app", c.sourceType, false, 10)).To(Equal(c.expected))
+		})
+	}
+
+	It("prunes refilled buckets only", func() {
+		t := newTestThrottle(1, 2, 0, false)
+		t.Allow("idle", "RTR", false)
+		t.buckets["idle"].last = time.Now().Add(-time.Minute)
+		t.Allow("busy", "RTR", false)
+		t.Prune()
+		Expect(t.buckets).To(HaveKey("busy"))
+		Expect(t.buckets).NotTo(HaveKey("idle"))
+	})
+
+	for _, c := range []struct {
+		value string
+		limit limit
+		fails bool
+	}{
+		{"5", limit{5, 5}, false},
+		{"5:20", limit{5, 20}, false},
+		{"x", limit{}, true},
+		{"5:x", limit{}, true},
+	} {
+		c := c
+		It("parses the limit "+c.value, func() {
+			l, err := parseLimit(c.value)
+			if c.fails {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(l).To(Equal(c.limit))
+		})
+	}
+})
@@ -0,0 +1,171 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmetric
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
+	"gopkg.in/yaml.v2"
+)
+
+// Pattern counts log lines containing a substring or matching a regular
+// expression, optionally limited to source types starting with SourceType.
+type Pattern struct {
+	Name       string `yaml:"name"`
+	Contains   string `yaml:"contains"`
+	Regex      string `yaml:"regex"`
+	SourceType string `yaml:"source_type"`
+	re         *regexp.Regexp
+}
+
+// Matches ...
+func (p *Pattern) Matches(message string, sourceType string) bool {
+	if len(p.SourceType) > 0 && !strings.HasPrefix(sourceType, p.SourceType) {
+		return false
+	}
+	if p.re != nil {
+		return p.re.MatchString(message)
+	}
+	return strings.Contains(message, p.Contains)
+}
+
+// Metrics extends metric.Accumulator for
+// Firehose LogMessage Envelope Event Types, counting lines matching patterns
+type Metrics struct {
+	accumulators.Accumulator
+	CFAppManager *cfapps.CFAppManager
+	Patterns     []*Pattern
+}
+
+// New satisfies metric.Accumulator
+func (m Metrics) New() accumulators.Interface {
+	i := Metrics{
+		Accumulator: accumulators.NewAccumulator(
+			"*loggregator_v2.Envelope_Log",
+		),
+		CFAppManager: cfapps.GetInstance(),
+	}
+	patterns, err := LoadPatterns(i.Config())
+	if err != nil {
+		app.Get().Log.Fatalf("invalid LOGMETRIC_PATTERNS: %s", err.Error())
+	}
+	if len(patterns) > 0 {
+		app.Get().Log.Infof("loaded %d log metric patterns", len(patterns))
+	}
+	i.Patterns = patterns
+	return i
+}
+
+// LoadPatterns from the LOGMETRIC_PATTERNS document
+func LoadPatterns(c *config.Config) (patterns []*Pattern, err error) {
+	doc, err := c.GetDocument("LOGMETRIC_PATTERNS")
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(doc, &patterns); err != nil {
+		return nil, err
+	}
+	for _, p := range patterns {
+		if len(p.Name) == 0 {
+			return nil, fmt.Errorf("pattern name is required")
+		}
+		if len(p.Regex) > 0 {
+			if p.re, err = regexp.Compile(p.Regex); err != nil {
+				return nil, fmt.Errorf("pattern %s: %s", p.Name, err.Error())
+			}
+			continue
+		}
+		if len(p.Contains) == 0 {
+			return nil, fmt.Errorf("pattern %s: contains or regex is required", p.Name)
+		}
+	}
+	return patterns, nil
+}
+
+// Update satisfies metric.Accumulator
+func (m Metrics) Update(e *loggregator_v2.Envelope) {
+	if len(m.Patterns) == 0 {
+		return
+	}
+
+	message := string(e.GetLog().Payload)
+	sourceType := m.GetTag(e, "source_type")
+
+	var entity *entities.Entity
+	for _, p := range m.Patterns {
+		if !p.Matches(message, sourceType) {
+			continue
+		}
+		if entity == nil {
+			entity = m.GetEntity(e, nrpcf.GetPCFAttributes(e))
+			entity.Attributes().AppendAll(
+				m.CFAppManager.GetAppInstanceAttributes(
+					e.GetSourceId(),
+					m.ConvertSourceInstance(e.GetInstanceId()),
+				),
+			)
+		}
+		entity.NewSample(
+			p.Name,
+			metrics.Types.Delta,
+			"lines",
+			1,
+		).Done()
+	}
+}
+
+// HarvestMetrics ...
+func (m Metrics) HarvestMetrics(
+	entity *entities.Entity,
+	metric *metrics.Metric,
+) {
+
+	metric.SetAttribute(
+		"eventType",
+		m.Config().GetString(config.NewRelicEventTypeLogMetric),
+	)
+
+	metric.SetAttribute("agent.subscription", m.Config().GetString("FIREHOSE_ID"))
+
+	metric.Attributes().
+		AppendAll(entity.Attributes())
+
+	// Get a client for this metric - checking for insert key and account ID info in the application
+	// We will default to what is in the configuration file	if application specific info isn't found
+	client := nrpcf.GetInsertClientForApp(entity)
+	client.EnqueueEventFrom("logmetric", metric.Marshal())
+
+}
+
+// GetTag ...
+func (m Metrics) GetTag(
+	e *loggregator_v2.Envelope,
+	ta string,
+) string {
+	if tv, ok := e.Tags[ta]; ok {
+		return tv
+	}
+	return ""
+}
+
+// ConvertSourceInstance from a string to int32
+func (m Metrics) ConvertSourceInstance(
+	i string,
+) int32 {
+	if num, err := strconv.ParseInt(i, 10, 32); err == nil {
+		return int32(num)
+	}
+	return 0
+}
@@ -0,0 +1,223 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmetric_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/logmetric"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
+	"github.com/spf13/viper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func patterns(doc string) ([]*logmetric.Pattern, error) {
+	c := &config.Config{Viper: viper.New()}
+	c.Set("LOGMETRIC_PATTERNS", doc)
+	return logmetric.LoadPatterns(c)
+}
+
+var _ = Describe("LoadPatterns", func() {
+	It("loads substring, regex and source type patterns", func() {
+		p, err := patterns(`
+- name: errors
+  contains: ERROR
+- name: oom
+  regex: '(?i)out of memory'
+  source_type: APP
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(HaveLen(2))
+		Expect(p[0].Name).To(Equal("errors"))
+		Expect(p[0].Contains).To(Equal("ERROR"))
+		Expect(p[1].Regex).To(Equal("(?i)out of memory"))
+		Expect(p[1].SourceType).To(Equal("APP"))
+	})
+
+	It("loads JSON", func() {
+		p, err := patterns(`[{"name": "errors", "contains": "ERROR"}]`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(HaveLen(1))
+	})
+
+	It("loads nothing when unset", func() {
+		p, err := patterns("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(BeEmpty())
+	})
+
+	It("reads the document from the _FILE path", func() {
+		dir, err := ioutil.TempDir("", "logmetric")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "patterns.yml")
+		Expect(ioutil.WriteFile(path, []byte("- name: errors\n  contains: ERROR\n"), 0600)).To(Succeed())
+
+		c := &config.Config{Viper: viper.New()}
+		c.Set("LOGMETRIC_PATTERNS_FILE", path)
+		p, err := logmetric.LoadPatterns(c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(HaveLen(1))
+	})
+
+	for _, c := range []struct {
+		name string
+		doc  string
+	}{
+		{"requires a name", `[{"contains": "ERROR"}]`},
+		{"requires contains or regex", `[{"name": "errors"}]`},
+		{"rejects invalid regexes", `[{"name": "errors", "regex": "("}]`},
+		{"rejects invalid documents", `{"name": "errors"}`},
+	} {
+		c := c
+		It(c.name, func() {
+			_, err := patterns(c.doc)
+			Expect(err).To(HaveOccurred())
+		})
+	}
+})
+
+var _ = Describe("Pattern", func() {
+	p, err := patterns(`
+- name: errors
+  contains: ERROR
+- name: oom
+  regex: '(?i)out of memory'
+- name: app-errors
+  contains: ERROR
+  source_type: APP
+`)
+	if err != nil {
+		panic(err)
+	}
+	errors, oom, appErrors := p[0], p[1], p[2]
+
+	for _, c := range []struct {
+		name       string
+		pattern    *logmetric.Pattern
+		message    string
+		sourceType string
+		matches    bool
+	}{
+		{"matches substrings", errors, "2020 ERROR failed", "RTR", true},
+		{"skips lines without the substring", errors, "2020 INFO ok", "RTR", false},
+		{"matches regexes", oom, "Out Of Memory: killed", "APP/PROC/WEB", true},
+		{"skips lines not matching the regex", oom, "memory ok", "APP/PROC/WEB", false},
+		{"matches source type prefixes", appErrors, "ERROR", "APP/PROC/WEB", true},
+		{"skips other source types", appErrors, "ERROR", "RTR", false},
+	} {
+		c := c
+		It(c.name, func() {
+			Expect(c.pattern.Matches(c.message, c.sourceType)).To(Equal(c.matches))
+		})
+	}
+})
+
+var _ = Describe("Update", func() {
+	var m logmetric.Metrics
+
+	envelope := func(guid string, instance string, message string) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId:   guid,
+			InstanceId: instance,
+			Tags:       map[string]string{"source_type": "APP/PROC/WEB"},
+			Message: &loggregator_v2.Envelope_Log{
+				Log: &loggregator_v2.Log{Payload: []byte(message)},
+			},
+		}
+	}
+
+	// update with an envelope, waiting for count metrics in all, since
+	// entities and metrics are put in their maps in the background
+	update := func(e *loggregator_v2.Envelope, count int) {
+		m.Update(e)
+		Eventually(func() int {
+			n := 0
+			m.Entities.ForEach(func(entity *entities.Entity) {
+				n += entity.ForEachMetric(func(*metrics.Metric) {})
+			})
+			return n
+		}).Should(Equal(count))
+	}
+
+	// counts of the drained metrics by app GUID, instance and metric name
+	drain := func() map[string]float64 {
+		appID := config.Get().AttributeName(config.EnvAppID)
+		index := config.Get().AttributeName(config.EnvAppInstanceIndex)
+		counts := map[string]float64{}
+		for _, entity := range m.Drain() {
+			key := entity.AttributeByName(appID).Value().(string) + "/" +
+				entity.AttributeByName(index).Value().(string)
+			for _, metric := range entity.DrainMetrics() {
+				Expect(metric.Type()).To(Equal(metrics.Types.Delta))
+				Expect(metric.Unit).To(Equal("lines"))
+				counts[key+"/"+metric.Name] += metric.Sum
+			}
+		}
+		return counts
+	}
+
+	BeforeEach(func() {
+		p, err := patterns(`
+- name: errors
+  contains: ERROR
+- name: timeouts
+  regex: 'timed? ?out'
+`)
+		Expect(err).NotTo(HaveOccurred())
+		manager := &cfapps.CFAppManager{Cache: cfapps.NewCache()}
+		manager.Cache.PutAll([]*cfapps.CFApp{cfapps.NewCFApp("app-1"), cfapps.NewCFApp("app-2")})
+		m = logmetric.Metrics{
+			Accumulator:  accumulators.NewAccumulator("*loggregator_v2.Envelope_Log"),
+			CFAppManager: manager,
+			Patterns:     p,
+		}
+	})
+
+	It("counts matches per app and instance", func() {
+		update(envelope("app-1", "0", "ERROR one"), 1)
+		update(envelope("app-1", "0", "ERROR two"), 1)
+		update(envelope("app-1", "1", "ERROR three"), 2)
+		update(envelope("app-2", "0", "request timed out"), 3)
+		update(envelope("app-2", "0", "ERROR request timeout"), 4)
+		update(envelope("app-2", "0", "INFO ok"), 4)
+
+		Expect(drain()).To(Equal(map[string]float64{
+			"app-1/0/errors":   2,
+			"app-1/1/errors":   1,
+			"app-2/0/timeouts": 2,
+			"app-2/0/errors":   1,
+		}))
+	})
+
+	It("resets the counts each harvest", func() {
+		update(envelope("app-1", "0", "ERROR one"), 1)
+		Expect(drain()).To(HaveLen(1))
+		Expect(drain()).To(BeEmpty())
+	})
+
+	It("counts lines when log message events are disabled", func() {
+		os.Setenv("NRF_LOGMESSAGE_EVENTS_ENABLED", "false")
+		defer os.Unsetenv("NRF_LOGMESSAGE_EVENTS_ENABLED")
+		Expect(config.Get().GetBool("LOGMESSAGE_EVENTS_ENABLED")).To(BeFalse())
+
+		update(envelope("app-1", "0", "ERROR one"), 1)
+		Expect(drain()).To(Equal(map[string]float64{"app-1/0/errors": 1}))
+	})
+
+	It("ignores lines without patterns", func() {
+		m.Patterns = nil
+		update(envelope("app-1", "0", "ERROR one"), 0)
+		Expect(drain()).To(BeEmpty())
+	})
+})
@@ -5,11 +5,9 @@ package value
 
 import (
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
-	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
-	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/insights"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
 )
@@ -64,8 +62,8 @@ func (m Metrics) HarvestMetrics(
 
 	metric.Attributes().AppendAll(entity.Attributes())
 
-	// Get a client with the insert key and RPM account ID from the config.
-	client := insights.New().Get(app.Get().Config.GetNewRelicConfig())
-	client.EnqueueEvent(metric.Marshal())
+	// Get a client for the account the deployment and job are routed to.
+	client := nrpcf.GetInsertClientForPlatform(entity)
+	client.EnqueueEventFrom("value", metric.Marshal())
 
 }
@@ -0,0 +1,101 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// auditTimeFormat of created_at timestamps and filters
+const auditTimeFormat = "2006-01-02T15:04:05Z"
+
+// auditDelete tombstones the app rather than refreshing it
+const auditDelete = "audit.app.delete-request"
+
+// auditPoller tracks the last processed audit event, events created in the
+// same second as the last one are remembered so they are not processed twice.
+type auditPoller struct {
+	last string
+	seen map[string]bool
+}
+
+// startAudit polls audit events on FIREHOSE_CACHE_AUDIT_INTERVAL
+func (c *CFAppManager) startAudit() {
+	interval := c.app.Config.GetDuration("FIREHOSE_CACHE_AUDIT_INTERVAL")
+	if interval <= 0 {
+		return
+	}
+	// earlier changes are covered by the startup sync or a first fetch
+	p := &auditPoller{
+		last: time.Now().UTC().Format(auditTimeFormat),
+		seen: map[string]bool{},
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := c.pollAuditEvents(p); err != nil {
+				c.app.Log.Warnf("audit event poll failed: %s", err.Error())
+			}
+		}
+	}()
+}
+
+// pollAuditEvents refreshes cached apps changed since the last poll,
+// and evicts deleted apps.
+func (c *CFAppManager) pollAuditEvents(p *auditPoller) error {
+	query := url.Values{}
+	query.Set("types", strings.Join(c.app.Config.GetFilter("FIREHOSE_CACHE_AUDIT_TYPES"), ","))
+	query.Set("created_ats[gte]", p.last)
+	query.Set("order_by", "created_at")
+	query.Set("per_page", fmt.Sprint(c.app.Config.GetInt("FIREHOSE_CACHE_SYNC_PAGE_SIZE")))
+
+	var events []v3Resource
+	err := c.listV3("/v3/audit_events?"+query.Encode(), func(page *v3Page) {
+		events = append(events, page.Resources...)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the latest event of each app decides between refresh and eviction
+	latest := map[string]v3Resource{}
+	for _, e := range events {
+		if e.CreatedAt == p.last && p.seen[e.GUID] {
+			continue
+		}
+		if e.CreatedAt != p.last {
+			p.last = e.CreatedAt
+			p.seen = map[string]bool{}
+		}
+		p.seen[e.GUID] = true
+
+		if e.Target.Type == "app" {
+			latest[e.Target.GUID] = e
+		}
+	}
+	for _, e := range latest {
+		c.applyAuditEvent(e)
+	}
+	return nil
+}
+
+func (c *CFAppManager) applyAuditEvent(e v3Resource) {
+	// apps not yet cached are fetched when first seen
+	a, found := c.Cache.Get(e.Target.GUID)
+	if !found {
+		return
+	}
+	if e.Type == auditDelete {
+		// log lines still in flight keep the app's attributes until the grace period ends
+		a.Lock.Lock()
+		a.markDeleted()
+		a.Lock.Unlock()
+		return
+	}
+	c.app.Log.Debugf("refreshing app %s after %s", e.Target.GUID, e.Type)
+	c.updateAppAsync(a)
+}
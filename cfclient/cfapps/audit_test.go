@@ -0,0 +1,62 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("applyAuditEvent", func() {
+	var c *CFAppManager
+
+	deleteRequest := func(guid string) v3Resource {
+		return v3Resource{Type: auditDelete, Target: v3Target{GUID: guid, Type: "app"}}
+	}
+
+	BeforeEach(func() {
+		c = &CFAppManager{app: app.Get(), Cache: NewCache()}
+	})
+
+	It("tombstones deleted apps until the grace period ends", func() {
+		c.Cache.PutAll([]*CFApp{NewCFApp("guid")})
+		c.applyAuditEvent(deleteRequest("guid"))
+
+		a, found := c.Cache.Get("guid")
+		Expect(found).To(BeTrue())
+		Expect(a.IsDeleted()).To(BeTrue())
+		Expect(a.Attributes.Marshal()).To(HaveKeyWithValue(AppDeleted, true))
+
+		c.Cache.evictDeleted(time.Minute)
+		_, found = c.Cache.Get("guid")
+		Expect(found).To(BeTrue())
+
+		a.DeletedAt = a.DeletedAt.Add(-2 * time.Minute)
+		c.Cache.evictDeleted(time.Minute)
+		_, found = c.Cache.Get("guid")
+		Expect(found).To(BeFalse())
+	})
+
+	It("reports tombstoned and evicted apps", func() {
+		removed := []string{}
+		Removed = func(guid string) { removed = append(removed, guid) }
+		defer func() { Removed = func(string) {} }()
+
+		c.Cache.PutAll([]*CFApp{NewCFApp("guid")})
+		c.applyAuditEvent(deleteRequest("guid"))
+		Expect(removed).To(Equal([]string{"guid"}))
+		c.Cache.Delete("guid")
+		Expect(removed).To(Equal([]string{"guid", "guid"}))
+	})
+
+	It("ignores apps that are not cached", func() {
+		c.applyAuditEvent(deleteRequest("unknown"))
+		_, found := c.Cache.Get("unknown")
+		Expect(found).To(BeFalse())
+	})
+})
@@ -10,6 +10,10 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 )
 
+// Removed is called with the GUID of each app tombstoned or evicted from the
+// cache, set at startup to avoid importing nrpcf.
+var Removed = func(guid string) {}
+
 // Cache ...
 type Cache struct {
 	Collection  map[string]*CFApp
@@ -32,25 +36,28 @@ func NewCache() *Cache {
 func (c *Cache) Start() {
 	go func() {
 		cacheDuration := app.Get().Config.GetDuration("FIREHOSE_CACHE_DURATION_MINS")
+		purge := time.NewTicker(cacheDuration * time.Minute)
+		grace := app.Get().Config.GetDuration("FIREHOSE_CACHE_DELETED_GRACE")
+		tombstones := time.NewTicker(time.Minute)
 		for {
 			select {
 
-			case <-time.NewTicker(cacheDuration * time.Minute).C:
+			case <-tombstones.C:
+				c.evictDeleted(grace)
+
+			case <-purge.C:
 				Collection := map[string]*CFApp{}
 				c.sync.Lock()
 				for k, v := range c.Collection {
 					if time.Since(v.LastPull) < cacheDuration*time.Minute {
 						Collection[k] = v
+					} else {
+						Removed(k)
 					}
-					c.Collection = Collection
 				}
+				c.Collection = Collection
 				c.sync.Unlock()
 
-			case <-time.NewTicker(30 * time.Second).C:
-				for _, v := range c.Collection {
-					v.UpdateInstances()
-				}
-
 			case app := <-c.WriteBuffer:
 				c.sync.Lock()
 				c.Collection[app.GUID] = app
@@ -76,6 +83,34 @@ func (c *Cache) Put(app *CFApp) {
 	c.WriteBuffer <- app
 }
 
+// List of cached apps
+func (c *Cache) List() []*CFApp {
+	c.sync.RLock()
+	defer c.sync.RUnlock()
+	apps := make([]*CFApp, 0, len(c.Collection))
+	for _, v := range c.Collection {
+		apps = append(apps, v)
+	}
+	return apps
+}
+
+// Delete evicts an app
+func (c *Cache) Delete(id string) {
+	c.sync.Lock()
+	delete(c.Collection, id)
+	c.sync.Unlock()
+	Removed(id)
+}
+
+// PutAll adds apps at once, bypassing the write buffer
+func (c *Cache) PutAll(apps []*CFApp) {
+	c.sync.Lock()
+	defer c.sync.Unlock()
+	for _, app := range apps {
+		c.Collection[app.GUID] = app
+	}
+}
+
 // Drain ...
 func (c *Cache) Drain() map[string]*CFApp {
 	c.sync.Lock()
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cfclient "github.com/cloudfoundry-community/go-cfclient"
@@ -21,26 +22,55 @@ var cfg = app.Get().Config
 
 // nolint
 var (
-	AppName             = cfg.GetString(config.EnvAppName)
-	AppSpaceName        = cfg.GetString(config.EnvAppSpaceName)
-	AppOrgName          = cfg.GetString(config.EnvAppOrgName)
-	AppID               = cfg.GetString(config.EnvAppID)
-	AppInstanceIndex    = cfg.GetString(config.EnvAppInstanceIndex)
-	AppInstanceState    = cfg.GetString(config.EnvAppInstanceState)
-	AppInstanceUID      = cfg.GetString(config.EnvAppInstanceUID)
-	AppInstancesDesired = cfg.GetString(config.EnvAppInstancesDesired)
+	AppName              = cfg.GetString(config.EnvAppName)
+	AppSpaceName         = cfg.GetString(config.EnvAppSpaceName)
+	AppOrgName           = cfg.GetString(config.EnvAppOrgName)
+	AppID                = cfg.GetString(config.EnvAppID)
+	AppInstanceIndex     = cfg.GetString(config.EnvAppInstanceIndex)
+	AppInstanceState     = cfg.GetString(config.EnvAppInstanceState)
+	AppInstanceUID       = cfg.GetString(config.EnvAppInstanceUID)
+	AppInstancesDesired  = cfg.GetString(config.EnvAppInstancesDesired)
+	AppOrgID             = cfg.GetString(config.EnvAppOrgID)
+	AppSpaceID           = cfg.GetString(config.EnvAppSpaceID)
+	AppBuildpack         = cfg.GetString(config.EnvAppBuildpack)
+	AppDetectedBuildpack = cfg.GetString(config.EnvAppDetectedBuildpack)
+	AppStack             = cfg.GetString(config.EnvAppStack)
+	AppMemoryLimit       = cfg.GetString(config.EnvAppMemoryLimit)
+	AppDiskLimit         = cfg.GetString(config.EnvAppDiskLimit)
+	AppHealthCheckType   = cfg.GetString(config.EnvAppHealthCheckType)
+	AppState             = cfg.GetString(config.EnvAppState)
+	AppIsolationSegment  = cfg.GetString(config.EnvAppIsolationSegment)
+	AppDeleted           = cfg.GetString(config.EnvAppDeleted)
+	AppStale             = cfg.GetString(config.EnvAppStale)
 )
 
 // CFApp Extended
 type CFApp struct {
+	// unix nanoseconds of the last envelope and last container metric, kept
+	// first for 64-bit alignment of atomic access
+	lastSeen     int64
+	lastMetric   int64
 	Attributes   *attributes.Attributes
 	GUID         string
 	App          *cfclient.App
 	Summaries    map[int32]string
 	VcapServices map[string]interface{}
 	LastPull     time.Time
-	Lock         *sync.RWMutex
-	retryCount   int32
+	// Stale apps were loaded from a snapshot and not refreshed since
+	Stale bool
+	// Deleted apps returned 404 from the CF API and are no longer refreshed
+	Deleted    bool
+	DeletedAt  time.Time
+	Lock       *sync.RWMutex
+	retryCount int32
+	// attribute names set from v3 labels and annotations
+	metadataNames []string
+	// set for synced apps until the app is fetched when first seen
+	needsDetails int32
+	// set while an instance state refresh is in flight
+	refreshing int32
+	// set once bindings and instance states were requested after a fetch
+	detailed bool
 }
 
 // NewSummary ...
@@ -96,19 +126,45 @@ func (a *CFApp) GetInstanceAttributes(id int32) (attrs *attributes.Attributes) {
 	return attrs
 }
 
-// GetAttributes returns Attribute struct with methods ...
-// func (a *CFApp) GetAttributes() *attributes.Attributes {
-// 	return a.Attributes
-// }
+// GetAttributes returns a copy of the app level attributes
+func (a *CFApp) GetAttributes() (attrs *attributes.Attributes) {
+	attrs = attributes.NewAttributes()
+	a.Lock.RLock()
+	defer a.Lock.RUnlock()
+	attrs.AppendAll(a.Attributes)
+	return attrs
+}
+
+// MarkContainerMetric records a container metric, apps emitting them get
+// their instance states refreshed first
+func (a *CFApp) MarkContainerMetric() {
+	atomic.StoreInt64(&a.lastMetric, time.Now().UnixNano())
+}
+
+// hasInstancesNotRunning ...
+func (a *CFApp) hasInstancesNotRunning() bool {
+	a.Lock.RLock()
+	defer a.Lock.RUnlock()
+	for _, state := range a.Summaries {
+		if state != "RUNNING" {
+			return true
+		}
+	}
+	return false
+}
 
 // UpdateInstances ...
 func (a *CFApp) UpdateInstances() {
+	a.updateInstances(PriorityLow)
+}
 
-	defer GetInstance().rateManager.Done()
-	if timeout := GetInstance().rateManager.Wait(); timeout != nil {
+func (a *CFApp) updateInstances(p Priority) {
+
+	if timeout := GetInstance().rateManager.Wait(p); timeout != nil {
 		app.Get().Log.Errorln("API timeout, app instances failed to update states")
 		return
 	}
+	defer GetInstance().rateManager.Done()
 
 	states, err := GetInstance().GetAppInstances(a.GUID)
 
@@ -116,14 +172,16 @@ func (a *CFApp) UpdateInstances() {
 	defer a.Lock.Unlock()
 
 	if err != nil {
+		if isNotFound(err) {
+			a.markDeleted()
+			return
+		}
+		app.Get().Log.Warnf("UpdateInstances failed on GUID %s: %v", a.GUID, err)
 		if strings.Contains(err.Error(), "401 Unauthorized") {
 			//401 unauthorized -- token has expired so we need to refresh the client
-			app.Get().Log.Warn("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
+			app.Get().Log.Warnf("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
 			go GetInstance().UpdateClient()
 		}
-		if _, found := a.Summaries[0]; found {
-			a.Summaries[0] = err.Error()
-		}
 		return
 	}
 
@@ -148,23 +206,39 @@ func (a *CFApp) UpdateInstances() {
 // consumed by applicaton specific accumulators (ContainerMetric and LogMessage)
 func (a *CFApp) GetAppEnv() {
 
-	defer GetInstance().rateManager.Done()
-	if timeout := GetInstance().rateManager.Wait(); timeout != nil {
+	if timeout := GetInstance().rateManager.Wait(PriorityNormal); timeout != nil {
 		app.Get().Log.Errorln("api timeout, GetAppEnv failed to update")
 		return
 	}
+	defer GetInstance().rateManager.Done()
 	env, err := GetInstance().GetAppEnv(a.GUID)
+	if isNotFound(err) {
+		a.Lock.Lock()
+		a.markDeleted()
+		a.Lock.Unlock()
+		return
+	}
 	if err != nil {
 		app.Get().Log.Errorf("GetAppEnv failed: %v", err)
 		if strings.Contains(err.Error(), "401 Unauthorized") {
 			//401 unauthorized -- token has expired so we need to refresh the client
-			app.Get().Log.Warn("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
+			app.Get().Log.Warnf("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
 			go GetInstance().UpdateClient()
 		}
 		return
 	}
+	services, _ := env.SystemEnv["VCAP_SERVICES"].(map[string]interface{})
+	if services == nil {
+		services = map[string]interface{}{}
+	}
+	// bindings with credentials stored in CredHub carry references only
+	if ch := GetInstance().credhub; ch != nil {
+		if err := ch.Resolve(services); err != nil {
+			app.Get().Log.Warnf("app %s: %s", a.GUID, err.Error())
+		}
+	}
 	a.Lock.Lock()
-	a.VcapServices = env.SystemEnv["VCAP_SERVICES"].(map[string]interface{})
+	a.VcapServices = services
 	a.Lock.Unlock()
 	GetInstance().app.Log.Tracer("V")
 }
@@ -0,0 +1,102 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// sharedSegment is the isolation segment of spaces and orgs without one
+const sharedSegment = "shared"
+
+// megabyte converts CF API memory and disk limits to bytes,
+// matching app.memory.quota and app.disk.quota
+const megabyte = 1024 * 1024
+
+// names of stacks and isolation segments by GUID, these rarely change.
+// Each sync lists them all, others are fetched when first needed.
+var names = map[string]string{}
+var namesLock = &sync.RWMutex{}
+
+func setName(guid string, name string) {
+	namesLock.Lock()
+	names[guid] = name
+	namesLock.Unlock()
+}
+
+// resourceName looks up the name of a v3 resource such as stacks or
+// isolation_segments, returning an empty string when it can't be fetched.
+func (c *CFAppManager) resourceName(kind string, guid string) string {
+	if len(guid) == 0 {
+		return ""
+	}
+	namesLock.RLock()
+	name, found := names[guid]
+	namesLock.RUnlock()
+	if found {
+		return name
+	}
+
+	r := v3Resource{}
+	if err := c.getV3(fmt.Sprintf("/v3/%s/%s", kind, guid), PriorityNormal, &r); err != nil {
+		c.app.Log.Warnf("unable to get %s %s: %s", kind, guid, err.Error())
+		return ""
+	}
+	setName(guid, r.Name)
+	return r.Name
+}
+
+// isolationSegment of a space, or the default of its org
+func (c *CFAppManager) isolationSegment(space cfclient.Space) string {
+	guid := space.IsolationSegmentGuid
+	if len(guid) == 0 {
+		guid = space.OrgData.Entity.DefaultIsolationSegmentGuid
+	}
+	if len(guid) == 0 {
+		return sharedSegment
+	}
+	return c.resourceName("isolation_segments", guid)
+}
+
+// setDetails from a v2 app fetched with its space and org, callers hold the lock
+func (a *CFApp) setDetails(result cfclient.App, stack string, segment string) {
+	a.Attributes.SetAttribute(AppSpaceID, result.SpaceGuid)
+	a.Attributes.SetAttribute(AppOrgID, result.SpaceData.Entity.OrganizationGuid)
+	a.Attributes.SetAttribute(AppBuildpack, result.Buildpack)
+	a.Attributes.SetAttribute(AppDetectedBuildpack, result.DetectedBuildpack)
+	a.Attributes.SetAttribute(AppMemoryLimit, int64(result.Memory)*megabyte)
+	a.Attributes.SetAttribute(AppDiskLimit, int64(result.DiskQuota)*megabyte)
+	a.Attributes.SetAttribute(AppHealthCheckType, result.HealthCheckType)
+	a.Attributes.SetAttribute(AppState, result.State)
+	if len(stack) > 0 {
+		a.Attributes.SetAttribute(AppStack, stack)
+	}
+	if len(segment) > 0 {
+		a.Attributes.SetAttribute(AppIsolationSegment, segment)
+	}
+}
+
+// setSyncDetails from the v3 app, space and web process, callers hold the lock.
+// The detected buildpack is only set for apps fetched individually.
+func (a *CFApp) setSyncDetails(r v3Resource, space v3Resource, process v3Resource, segment string) {
+	a.Attributes.SetAttribute(AppSpaceID, space.GUID)
+	a.Attributes.SetAttribute(AppIsolationSegment, segment)
+	a.Attributes.SetAttribute(AppOrgID, space.related("organization"))
+	a.Attributes.SetAttribute(AppState, r.State)
+	if len(r.Lifecycle.Data.Buildpacks) > 0 {
+		a.Attributes.SetAttribute(AppBuildpack, strings.Join(r.Lifecycle.Data.Buildpacks, ","))
+	}
+	if len(r.Lifecycle.Data.Stack) > 0 {
+		a.Attributes.SetAttribute(AppStack, r.Lifecycle.Data.Stack)
+	}
+	if len(process.GUID) > 0 {
+		a.Attributes.SetAttribute(AppMemoryLimit, int64(process.MemoryInMB)*megabyte)
+		a.Attributes.SetAttribute(AppDiskLimit, int64(process.DiskInMB)*megabyte)
+		a.Attributes.SetAttribute(AppHealthCheckType, process.HealthCheck.Type)
+	}
+}
@@ -4,84 +4,268 @@
 package cfapps
 
 import (
+	"context"
 	"errors"
+	"math"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 )
 
+// Priority of a CF API call, higher priorities are served first
+type Priority int
+
+// nolint
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+var errRateTimeout = errors.New("rate-limiter timeout")
+var errRateClosed = errors.New("rate-limiter closed")
+
+// RateStats of the CF API rate limiter
+type RateStats struct {
+	Queued   int
+	Active   int
+	Granted  int64
+	Timeouts int64
+	// WaitTime of every granted call since start
+	WaitTime time.Duration
+}
+
+type waiter struct {
+	ready    chan struct{}
+	priority Priority
+	queued   time.Time
+}
+
+// rateManager limits concurrent CF API calls and calls per second with a
+// token bucket, serving queued calls by priority.
 type rateManager struct {
-	burstLimit   int32
-	active       int32
-	queued       int32
-	delay        time.Duration
-	noQueueDelay time.Duration
-	timeout      time.Duration
-	nextChan     chan bool
+	concurrency int
+	qps         float64
+	burst       float64
+	timeout     time.Duration
+	ctx         context.Context
+	cancel      context.CancelFunc
+	lock        *sync.Mutex
+	active      int
+	tokens      float64
+	last        time.Time
+	queues      [PriorityHigh + 1][]*waiter
+	wake        chan struct{}
+	stopped     chan struct{}
+	granted     int64
+	timeouts    int64
+	waitTime    int64
 }
 
 func newRateManager() *rateManager {
+	cfg := app.Get().Config
+	return startRateManager(
+		cfg.GetInt("FIREHOSE_RATE_BURST"),
+		cfg.GetFloat64("FIREHOSE_RATE_QPS"),
+		cfg.GetFloat64("FIREHOSE_RATE_QPS_BURST"),
+		cfg.GetDuration("FIREHOSE_RATE_TIMEOUT_SECS")*time.Second,
+	)
+}
+
+// startRateManager allowing concurrency calls at once and qps calls per second
+// with bursts of up to burst calls, a qps of 0 is unlimited
+func startRateManager(concurrency int, qps float64, burst float64, timeout time.Duration) *rateManager {
 	rm := &rateManager{
-		burstLimit:   app.Get().Config.GetInt32("FIREHOSE_RATE_BURST"),
-		queued:       0,
-		active:       0,
-		delay:        50 * time.Millisecond,
-		noQueueDelay: time.Second,
-		timeout:      app.Get().Config.GetDuration("FIREHOSE_RATE_TIMEOUT_SECS") * time.Second,
-		nextChan:     make(chan bool),
+		concurrency: concurrency,
+		qps:         qps,
+		burst:       burst,
+		timeout:     timeout,
+		lock:        &sync.Mutex{},
+		last:        time.Now(),
+		wake:        make(chan struct{}, 1),
+		stopped:     make(chan struct{}),
 	}
-	go func() {
-		for {
-			if atomic.LoadInt32(&rm.active) <= rm.burstLimit {
-				atomic.AddInt32(&rm.active, 1)
-				rm.nextChan <- true
-				continue
-			}
+	rm.ctx, rm.cancel = context.WithCancel(context.Background())
+	if rm.concurrency < 1 {
+		rm.concurrency = 1
+	}
+	if rm.burst < 1 {
+		rm.burst = math.Max(rm.qps, 1)
+	}
+	rm.tokens = rm.burst
+	go rm.run()
+	return rm
+}
 
-			if rm.HasQueue() {
-				time.Sleep(rm.delay)
-			} else {
-				time.Sleep(rm.noQueueDelay)
+// run grants queued calls as concurrency and tokens allow, until closed
+func (b *rateManager) run() {
+	defer close(b.stopped)
+	for {
+		b.lock.Lock()
+		next := b.dispatch()
+		b.lock.Unlock()
+
+		if next > 0 {
+			select {
+			case <-b.wake:
+			case <-time.After(next):
+			case <-b.ctx.Done():
+				return
 			}
+			continue
+		}
+		select {
+		case <-b.wake:
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
 
+// dispatch grants what it can, returning how long until the next token
+// when calls are waiting on one, or 0 to wait for a wake up.
+func (b *rateManager) dispatch() time.Duration {
+	if b.qps > 0 {
+		now := time.Now()
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.qps)
+		b.last = now
+	}
+	for b.active < b.concurrency {
+		w := b.pop()
+		if w == nil {
+			return 0
 		}
-	}()
-	return rm
+		if b.qps > 0 {
+			if b.tokens < 1 {
+				b.push(w, true)
+				return time.Duration((1 - b.tokens) / b.qps * float64(time.Second))
+			}
+			b.tokens--
+		}
+		b.active++
+		atomic.AddInt64(&b.granted, 1)
+		atomic.AddInt64(&b.waitTime, int64(time.Since(w.queued)))
+		close(w.ready)
+	}
+	return 0
 }
 
-func (b *rateManager) Done() {
-	atomic.AddInt32(&b.active, -1)
+// pop the oldest call of the highest priority
+func (b *rateManager) pop() *waiter {
+	for p := PriorityHigh; p >= PriorityLow; p-- {
+		if q := b.queues[p]; len(q) > 0 {
+			b.queues[p] = q[1:]
+			return q[0]
+		}
+	}
+	return nil
 }
 
-// HasQueue ...
-func (b *rateManager) HasQueue() bool {
-	return atomic.LoadInt32(&b.queued) > 0
+func (b *rateManager) push(w *waiter, front bool) {
+	if front {
+		b.queues[w.priority] = append([]*waiter{w}, b.queues[w.priority]...)
+		return
+	}
+	b.queues[w.priority] = append(b.queues[w.priority], w)
 }
 
-// GetQueued ...
-func (b *rateManager) GetQueued() int32 {
-	return atomic.LoadInt32(&b.queued)
+// remove a waiter still queued, reporting false when it was already granted
+func (b *rateManager) remove(w *waiter) bool {
+	q := b.queues[w.priority]
+	for i := range q {
+		if q[i] == w {
+			b.queues[w.priority] = append(q[:i:i], q[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
 
-// GetActive ...
-func (b *rateManager) GetActive() int32 {
-	return atomic.LoadInt32(&b.active)
+func (b *rateManager) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
 }
 
-func (b *rateManager) Wait() error {
+// Wait for a call slot, giving up after FIREHOSE_RATE_TIMEOUT_SECS or once
+// the limiter is closed. Done must be called once the call completes, only
+// when Wait succeeds.
+func (b *rateManager) Wait(p Priority) error {
+	ctx, cancel := context.WithTimeout(b.ctx, b.timeout)
+	defer cancel()
 
-	atomic.AddInt32(&b.queued, 1)
-	defer atomic.AddInt32(&b.queued, -1)
+	w := &waiter{ready: make(chan struct{}), priority: p, queued: time.Now()}
+	b.lock.Lock()
+	b.push(w, false)
+	b.lock.Unlock()
+	b.signal()
 
 	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
 
-	case <-b.nextChan:
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.remove(w) {
+		// granted while the context was ending, keep the slot
 		return nil
+	}
+	if b.ctx.Err() != nil {
+		return errRateClosed
+	}
+	atomic.AddInt64(&b.timeouts, 1)
+	return errRateTimeout
+}
+
+// Close the limiter and stop its dispatcher, calls still waiting give up
+func (b *rateManager) Close() {
+	b.cancel()
+	<-b.stopped
+}
+
+// Done releases a call slot
+func (b *rateManager) Done() {
+	b.lock.Lock()
+	b.active--
+	b.lock.Unlock()
+	b.signal()
+}
 
-	case <-time.After(b.timeout):
-		return errors.New("rate-limiter timeout")
+// HasQueue ...
+func (b *rateManager) HasQueue() bool {
+	return b.GetQueued() > 0
+}
 
+// GetQueued ...
+func (b *rateManager) GetQueued() int32 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	queued := 0
+	for _, q := range b.queues {
+		queued += len(q)
 	}
+	return int32(queued)
+}
 
+// GetActive ...
+func (b *rateManager) GetActive() int32 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return int32(b.active)
+}
+
+// Stats of the limiter, counters are totals since start
+func (b *rateManager) Stats() RateStats {
+	return RateStats{
+		Queued:   int(b.GetQueued()),
+		Active:   int(b.GetActive()),
+		Granted:  atomic.LoadInt64(&b.granted),
+		Timeouts: atomic.LoadInt64(&b.timeouts),
+		WaitTime: time.Duration(atomic.LoadInt64(&b.waitTime)),
+	}
 }
@@ -0,0 +1,115 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// queue a call, appending its name to granted once it gets a slot
+func queue(rm *rateManager, p Priority, name string, granted *[]string, lock *sync.Mutex) {
+	queued := rm.GetQueued()
+	go func() {
+		if rm.Wait(p) != nil {
+			return
+		}
+		lock.Lock()
+		*granted = append(*granted, name)
+		lock.Unlock()
+	}()
+	Eventually(rm.GetQueued).Should(Equal(queued + 1))
+}
+
+var _ = Describe("rateManager", func() {
+	var rm *rateManager
+
+	AfterEach(func() {
+		rm.Close()
+	})
+
+	It("serves queued calls by priority, oldest first", func() {
+		rm = startRateManager(1, 0, 0, time.Minute)
+		Expect(rm.Wait(PriorityNormal)).To(Succeed())
+
+		granted := []string{}
+		lock := &sync.Mutex{}
+		queue(rm, PriorityLow, "low", &granted, lock)
+		queue(rm, PriorityNormal, "normal 1", &granted, lock)
+		queue(rm, PriorityHigh, "high", &granted, lock)
+		queue(rm, PriorityNormal, "normal 2", &granted, lock)
+
+		for i := 1; i <= 4; i++ {
+			rm.Done()
+			Eventually(func() int {
+				lock.Lock()
+				defer lock.Unlock()
+				return len(granted)
+			}).Should(Equal(i))
+		}
+		Expect(granted).To(Equal([]string{"high", "normal 1", "normal 2", "low"}))
+	})
+
+	It("limits concurrent calls", func() {
+		rm = startRateManager(2, 0, 0, 50*time.Millisecond)
+		Expect(rm.Wait(PriorityLow)).To(Succeed())
+		Expect(rm.Wait(PriorityLow)).To(Succeed())
+		Expect(rm.Wait(PriorityHigh)).To(MatchError(errRateTimeout))
+		Expect(rm.GetActive()).To(Equal(int32(2)))
+		rm.Done()
+		Expect(rm.Wait(PriorityHigh)).To(Succeed())
+	})
+
+	It("counts timeouts without leaking slots", func() {
+		rm = startRateManager(1, 0, 0, 20*time.Millisecond)
+		Expect(rm.Wait(PriorityNormal)).To(Succeed())
+		Expect(rm.Wait(PriorityNormal)).To(MatchError(errRateTimeout))
+		rm.Done()
+
+		stats := rm.Stats()
+		Expect(stats.Timeouts).To(Equal(int64(1)))
+		Expect(stats.Granted).To(Equal(int64(1)))
+		Expect(stats.Queued).To(Equal(0))
+		Expect(stats.Active).To(Equal(0))
+	})
+
+	It("limits calls per second after the burst", func() {
+		rm = startRateManager(10, 20, 2, time.Minute)
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			Expect(rm.Wait(PriorityNormal)).To(Succeed())
+			rm.Done()
+		}
+		// two calls from the burst, two more at 20 per second
+		Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+	})
+
+	It("gives up waiting calls once closed", func() {
+		rm = startRateManager(1, 0, 0, time.Minute)
+		Expect(rm.Wait(PriorityNormal)).To(Succeed())
+		done := make(chan error)
+		go func() { done <- rm.Wait(PriorityNormal) }()
+		Eventually(rm.GetQueued).Should(Equal(int32(1)))
+		rm.Close()
+		Eventually(done).Should(Receive(MatchError(errRateClosed)))
+		Expect(rm.Stats().Timeouts).To(BeZero())
+	})
+
+	It("stops dispatching once closed", func() {
+		for _, rm := range []*rateManager{
+			// idle, and waiting on a token
+			startRateManager(1, 0, 0, time.Minute),
+			startRateManager(2, 0.01, 1, time.Minute),
+		} {
+			Expect(rm.Wait(PriorityNormal)).To(Succeed())
+			go rm.Wait(PriorityNormal)
+			Eventually(rm.GetQueued).Should(Equal(int32(1)))
+			go rm.Close()
+			Eventually(rm.stopped).Should(BeClosed())
+		}
+	})
+})
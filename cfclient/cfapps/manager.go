@@ -13,7 +13,10 @@ import (
 
 	cfclient "github.com/cloudfoundry-community/go-cfclient"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/credhub"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/healthcheck"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/telemetry"
 )
 
 // Error ...
@@ -36,7 +39,10 @@ type CFAppManager struct {
 	clientLock  *sync.RWMutex
 	Cache       *Cache
 	rateManager *rateManager
-	closeChan   chan bool
+	partition   *partition
+	credhub     *credhub.Client
+	// set once the first sync is done, or when syncs are disabled
+	synced int32
 }
 
 // Start CFAppManager
@@ -50,8 +56,21 @@ func Start(app *app.Application) *CFAppManager {
 		clientLock:  &sync.RWMutex{},
 		Cache:       NewCache(),
 		rateManager: newRateManager(),
+		partition:   newPartition(app.Config.GetInt("CF_INSTANCE_INDEX")),
 	}
 
+	ch, err := credhub.FromConfig(app.Config)
+	if err != nil {
+		app.Log.Fatalf("unable to connect to credhub: %s", err.Error())
+	}
+	instance.credhub = ch
+
+	instance.LoadSnapshot()
+	instance.startSnapshots()
+	instance.startSync()
+	instance.startRefresh()
+	instance.startAudit()
+
 	return instance
 }
 
@@ -60,6 +79,11 @@ func GetInstance() *CFAppManager {
 	return instance
 }
 
+// RateStats of the CF API rate limiter
+func (c *CFAppManager) RateStats() RateStats {
+	return c.rateManager.Stats()
+}
+
 // GetAppInstanceAttributes ...
 func (c *CFAppManager) GetAppInstanceAttributes(
 	appID string,
@@ -79,19 +103,25 @@ func (c *CFAppManager) GetAppInstanceAttributes(
 func (c *CFAppManager) GetApp(guid string) (app *CFApp) {
 	var found bool
 	if app, found = c.Cache.Get(guid); found {
+		atomic.StoreInt64(&app.lastSeen, time.Now().UnixNano())
+		c.requestDetails(app)
 		return app
 	}
 	app = NewCFApp(guid)
+	app.lastSeen = time.Now().UnixNano()
 	c.Cache.Put(app)
 	c.updateAppAsync(app)
 	return
 }
 
 func (c *CFAppManager) updateAppAsync(app *CFApp) {
+	if app.IsDeleted() {
+		return
+	}
 	go func() {
 		if err := c.FetchApp(app); err != nil {
 			if atomic.LoadInt32(&app.retryCount) > 2 {
-				c.app.Log.Warn("Max retries trying to fetch app: %s", app.GUID)
+				c.app.Log.Warnf("Max retries trying to fetch app: %s", app.GUID)
 				return
 			}
 			atomic.AddInt32(&app.retryCount, 1)
@@ -103,18 +133,37 @@ func (c *CFAppManager) updateAppAsync(app *CFApp) {
 
 }
 
+// observeCall of the CF API, reported in PCFNozzleHealth events and
+// /health/ready. Apps not found are not errors of the API.
+func observeCall(start time.Time, err error) {
+	telemetry.Observe("cfapi.call", time.Since(start))
+	if isNotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		telemetry.Count("cfapi.errors", 1)
+	}
+	healthcheck.Track(healthcheck.CFAPI).Result(err)
+}
+
 // GetAppInstances ...
 func (c *CFAppManager) GetAppInstances(guid string) (map[string]cfclient.AppInstance, error) {
 	c.clientLock.RLock()
 	defer c.clientLock.RUnlock()
-	return c.client.GetAppInstances(guid)
+	start := time.Now()
+	instances, err := c.client.GetAppInstances(guid)
+	observeCall(start, err)
+	return instances, err
 }
 
 // GetAppEnv ...
 func (c *CFAppManager) GetAppEnv(guid string) (cfclient.AppEnv, error) {
 	c.clientLock.RLock()
 	defer c.clientLock.RUnlock()
-	return c.client.GetAppEnv(guid)
+	start := time.Now()
+	env, err := c.client.GetAppEnv(guid)
+	observeCall(start, err)
+	return env, err
 }
 
 // FetchApp ...
@@ -122,55 +171,82 @@ func (c *CFAppManager) FetchApp(a *CFApp) error {
 
 	c.app.Log.Tracer("å")
 
-	defer c.rateManager.Done()
-	if timeout := c.rateManager.Wait(); timeout != nil {
+	if timeout := c.rateManager.Wait(PriorityHigh); timeout != nil {
 		err := errors.New("timeout on update container app details: " + a.GUID)
 		c.app.Log.Warn(err)
 		return err
 	}
 
 	c.clientLock.RLock()
+	start := time.Now()
 	result, err := c.client.GetAppByGuid(a.GUID)
+	observeCall(start, err)
 	c.clientLock.RUnlock()
+	// stack and segment names wait for the limiter on their own
+	c.rateManager.Done()
 	c.app.Log.Tracer("^")
 
+	if isNotFound(err) {
+		a.Lock.Lock()
+		a.markDeleted()
+		a.Lock.Unlock()
+		return nil
+	}
 	if err != nil {
 		err = fmt.Errorf("CF api error %s on GUID %s", err.Error(), a.GUID)
 		c.app.Log.Warn(err)
 		if strings.Contains(err.Error(), "401 Unauthorized") {
 			//401 unauthorized -- token has expired so we need to refresh the client
-			app.Get().Log.Warn("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
+			app.Get().Log.Warnf("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
 			go c.UpdateClient()
 		}
 		return err
 	}
 
+	stack := c.resourceName("stacks", result.StackGuid)
+	segment := c.isolationSegment(result.SpaceData.Entity)
+
 	a.Lock.Lock()
 	defer a.Lock.Unlock()
 
 	a.App = &result
+	a.markFresh()
 
 	a.Attributes.SetAttribute(AppInstancesDesired, result.Instances)
 	a.Attributes.SetAttribute(AppName, result.Name)
 	a.Attributes.SetAttribute(AppOrgName, result.SpaceData.Entity.OrgData.Entity.Name)
 	a.Attributes.SetAttribute(AppSpaceName, result.SpaceData.Entity.Name)
+	a.setDetails(result, stack, segment)
 
 	a.LastPull = time.Now()
 
 	c.app.Log.Tracer("Å")
 
-	// need these requests in the back of the stack
-	go a.UpdateInstances()
-	go a.GetAppEnv()
+	c.updateDetails(a, MetadataEnabled())
 
 	return nil
 
 }
 
-// Close CFAppManager
+// updateDetails requests the instance states and bindings of the app, and its
+// metadata when asked, callers hold the lock
+func (c *CFAppManager) updateDetails(a *CFApp, metadata bool) {
+	// need these requests in the back of the stack,
+	// apps owned by other nozzle instances only get them once
+	if !a.detailed || c.Owns(a.GUID) {
+		a.detailed = true
+		go a.UpdateInstances()
+		go a.GetAppEnv()
+		if metadata {
+			go a.UpdateMetadata()
+		}
+	}
+}
+
+// Close CFAppManager, saving a final cache snapshot
 func (c *CFAppManager) Close() {
-	c.closeChan <- true
-	c.closeChan <- true
+	c.rateManager.Close()
+	c.SaveSnapshot()
 	c.app.Log.Info("closed CFAppManager")
 }
 
@@ -0,0 +1,218 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+)
+
+// Metadata labels and annotations of a v3 resource
+type Metadata struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// v3Relationship ...
+type v3Relationship struct {
+	Data struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+// v3Link to a related resource
+type v3Link struct {
+	Href string `json:"href"`
+}
+
+// v3Target of an audit event
+type v3Target struct {
+	GUID string `json:"guid"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// v3Resource holds the fields used from v3 apps, spaces, orgs, processes
+// and audit events
+type v3Resource struct {
+	GUID          string                    `json:"guid"`
+	Name          string                    `json:"name"`
+	State         string                    `json:"state"`
+	Instances     int                       `json:"instances"`
+	Metadata      Metadata                  `json:"metadata"`
+	Relationships map[string]v3Relationship `json:"relationships"`
+	Links         map[string]v3Link         `json:"links"`
+	CreatedAt     string                    `json:"created_at"`
+	Type          string                    `json:"type"`
+	Target        v3Target                  `json:"target"`
+	Lifecycle     v3Lifecycle               `json:"lifecycle"`
+	MemoryInMB    int                       `json:"memory_in_mb"`
+	DiskInMB      int                       `json:"disk_in_mb"`
+	HealthCheck   struct {
+		Type string `json:"type"`
+	} `json:"health_check"`
+}
+
+// v3Lifecycle of an app, the stack is a name rather than a GUID
+type v3Lifecycle struct {
+	Type string `json:"type"`
+	Data struct {
+		Buildpacks []string `json:"buildpacks"`
+		Stack      string   `json:"stack"`
+	} `json:"data"`
+}
+
+// related GUID of a to-one relationship
+func (r v3Resource) related(name string) string {
+	return r.Relationships[name].Data.GUID
+}
+
+// linked GUID of a related resource, the last segment of its link. Processes
+// have no app relationship, only a link to it.
+func (r v3Resource) linked(name string) string {
+	href := strings.TrimRight(r.Links[name].Href, "/")
+	return href[strings.LastIndex(href, "/")+1:]
+}
+
+// v3Included resources of an include= request
+type v3Included struct {
+	Spaces        []v3Resource `json:"spaces"`
+	Organizations []v3Resource `json:"organizations"`
+}
+
+// v3App is a v3 app with its space and org included
+type v3App struct {
+	v3Resource
+	Included v3Included `json:"included"`
+}
+
+// allowList of metadata keys, * allows every key
+type allowList map[string]bool
+
+func newAllowList(keys []string) allowList {
+	l := allowList{}
+	for _, k := range keys {
+		if k = strings.TrimSpace(k); len(k) > 0 {
+			l[k] = true
+		}
+	}
+	return l
+}
+
+func (l allowList) allows(key string) bool {
+	return l["*"] || l[key]
+}
+
+var labelKeys = newAllowList(cfg.GetFilter("METADATA_LABELS"))
+var annotationKeys = newAllowList(cfg.GetFilter("METADATA_ANNOTATIONS"))
+
+var guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// IsGUID reports whether a source id looks like an app GUID
+// rather than a platform component name.
+func IsGUID(id string) bool {
+	return guidPattern.MatchString(id)
+}
+
+// MetadataEnabled when any label or annotation key is allowed
+func MetadataEnabled() bool {
+	return len(labelKeys)+len(annotationKeys) > 0
+}
+
+// metadataAttributes names allowed labels <scope>.label.<key>
+// and allowed annotations <scope>.annotation.<key>
+func metadataAttributes(scope string, m Metadata, attrs *attributes.Attributes) {
+	for k, v := range m.Labels {
+		if labelKeys.allows(k) {
+			attrs.SetAttribute(fmt.Sprintf("%s.label.%s", scope, k), v)
+		}
+	}
+	for k, v := range m.Annotations {
+		if annotationKeys.allows(k) {
+			attrs.SetAttribute(fmt.Sprintf("%s.annotation.%s", scope, k), v)
+		}
+	}
+}
+
+// GetAppMetadata fetches the v3 app with its space and org
+func (c *CFAppManager) GetAppMetadata(guid string) (*v3App, error) {
+	c.clientLock.RLock()
+	defer c.clientLock.RUnlock()
+	r := c.client.NewRequest("GET", fmt.Sprintf("/v3/apps/%s?include=space.organization", guid))
+	start := time.Now()
+	resp, err := c.client.DoRequest(r)
+	observeCall(start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := &v3App{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("unable to decode v3 app %s: %s", guid, err.Error())
+	}
+	return result, nil
+}
+
+// UpdateMetadata replaces the app, space and org labels and annotations
+func (a *CFApp) UpdateMetadata() {
+
+	if timeout := GetInstance().rateManager.Wait(PriorityNormal); timeout != nil {
+		app.Get().Log.Errorln("API timeout, app metadata failed to update")
+		return
+	}
+	defer GetInstance().rateManager.Done()
+
+	result, err := GetInstance().GetAppMetadata(a.GUID)
+	if isNotFound(err) {
+		a.Lock.Lock()
+		a.markDeleted()
+		a.Lock.Unlock()
+		return
+	}
+	if err != nil {
+		app.Get().Log.Warnf("UpdateMetadata failed on GUID %s: %v", a.GUID, err)
+		if strings.Contains(err.Error(), "401 Unauthorized") {
+			//401 unauthorized -- token has expired so we need to refresh the client
+			app.Get().Log.Warnf("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
+			go GetInstance().UpdateClient()
+		}
+		return
+	}
+
+	var space, org v3Resource
+	if len(result.Included.Spaces) > 0 {
+		space = result.Included.Spaces[0]
+	}
+	if len(result.Included.Organizations) > 0 {
+		org = result.Included.Organizations[0]
+	}
+
+	a.Lock.Lock()
+	defer a.Lock.Unlock()
+	a.setMetadata(result.v3Resource, space, org)
+}
+
+// setMetadata replaces the metadata attributes, callers hold the lock
+func (a *CFApp) setMetadata(r v3Resource, space v3Resource, org v3Resource) {
+	attrs := attributes.NewAttributes()
+	metadataAttributes("app", r.Metadata, attrs)
+	metadataAttributes("space", space.Metadata, attrs)
+	metadataAttributes("org", org.Metadata, attrs)
+
+	// labels removed since the last update must not linger
+	for _, name := range a.metadataNames {
+		a.Attributes.Remove(name)
+	}
+	a.metadataNames = a.metadataNames[:0]
+	attrs.ForEach(func(attr *attributes.Attribute) {
+		a.Attributes.SetAttribute(attr.Name(), attr.Value())
+		a.metadataNames = append(a.metadataNames, attr.Name())
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync/atomic"
+)
+
+// partition assigns each app GUID to one owner nozzle instance, which does
+// the recurring CF API refreshes for it. Rendezvous hashing moves only the
+// apps of an added or removed instance when the instance count changes.
+type partition struct {
+	index int
+	count int32
+}
+
+func newPartition(index int) *partition {
+	return &partition{index: index, count: 1}
+}
+
+// owns reports whether this instance owns the app
+func (p *partition) owns(guid string) bool {
+	count := int(atomic.LoadInt32(&p.count))
+	if count <= 1 {
+		return true
+	}
+	return ownerOf(guid, count) == p.index
+}
+
+func (p *partition) setCount(count int) {
+	if count < 1 {
+		count = 1
+	}
+	atomic.StoreInt32(&p.count, int32(count))
+}
+
+// ownerOf returns the instance index with the highest hash of guid and index
+func ownerOf(guid string, count int) int {
+	owner := 0
+	var max uint64
+	for i := 0; i < count; i++ {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s/%d", guid, i)
+		if sum := mix(h.Sum64()); i == 0 || sum > max {
+			owner, max = i, sum
+		}
+	}
+	return owner
+}
+
+// mix spreads fnv's poorly mixed high bits, the murmur3 finalizer
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Owns reports whether this nozzle instance refreshes the app periodically,
+// other instances fetch it once when first seen.
+func (c *CFAppManager) Owns(guid string) bool {
+	return c.partition.owns(guid)
+}
+
+// updatePartition sets the instance count from FIREHOSE_INSTANCE_COUNT, or
+// from the nozzle's own web process when that is 0.
+func (c *CFAppManager) updatePartition() {
+	if !c.app.Config.GetBool("FIREHOSE_PARTITION_REFRESHES") {
+		return
+	}
+	if count := c.app.Config.GetInt("FIREHOSE_INSTANCE_COUNT"); count > 0 {
+		c.partition.setCount(count)
+		return
+	}
+
+	vcap := struct {
+		ApplicationID string `json:"application_id"`
+	}{}
+	if err := json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &vcap); err != nil || len(vcap.ApplicationID) == 0 {
+		return
+	}
+	var instances int
+	err := c.listV3(
+		fmt.Sprintf("/v3/apps/%s/processes?types=web", vcap.ApplicationID),
+		func(p *v3Page) {
+			for _, r := range p.Resources {
+				instances += r.Instances
+			}
+		},
+	)
+	if err != nil {
+		c.app.Log.Warnf("unable to get nozzle instance count: %s", err.Error())
+		return
+	}
+	if previous := int(atomic.LoadInt32(&c.partition.count)); previous != instances {
+		c.app.Log.Infof("partitioning app refreshes across %d nozzle instances", instances)
+	}
+	c.partition.setCount(instances)
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func guids(n int) []string {
+	g := make([]string, n)
+	for i := range g {
+		g[i] = fmt.Sprintf("%08x-0000-4000-8000-%012x", i, i*7919)
+	}
+	return g
+}
+
+var _ = Describe("ownerOf", func() {
+	for _, count := range []int{1, 2, 3, 5, 8} {
+		count := count
+		It(fmt.Sprintf("assigns every app to one of %d instances", count), func() {
+			for _, guid := range guids(200) {
+				owner := ownerOf(guid, count)
+				Expect(owner).To(BeNumerically(">=", 0))
+				Expect(owner).To(BeNumerically("<", count))
+				Expect(ownerOf(guid, count)).To(Equal(owner))
+			}
+		})
+	}
+
+	It("spreads apps evenly", func() {
+		owned := make([]int, 4)
+		for _, guid := range guids(4000) {
+			owned[ownerOf(guid, 4)]++
+		}
+		for _, n := range owned {
+			Expect(n).To(BeNumerically("~", 1000, 150))
+		}
+	})
+
+	for _, c := range []struct {
+		name string
+		from int
+		to   int
+	}{
+		{"moves only apps to an added instance", 3, 4},
+		{"moves only apps of a removed instance", 4, 3},
+	} {
+		c := c
+		It(c.name, func() {
+			moved := 0
+			for _, guid := range guids(2000) {
+				before, after := ownerOf(guid, c.from), ownerOf(guid, c.to)
+				if before == after {
+					continue
+				}
+				moved++
+				if c.to > c.from {
+					Expect(after).To(Equal(c.to - 1))
+				} else {
+					Expect(before).To(Equal(c.from - 1))
+				}
+			}
+			Expect(moved).To(BeNumerically(">", 0))
+		})
+	}
+})
+
+var _ = Describe("partition", func() {
+	for _, c := range []struct {
+		name  string
+		count int
+		owns  int
+	}{
+		{"owns every app alone", 1, 100},
+		{"owns every app with an invalid count", 0, 100},
+	} {
+		c := c
+		It(c.name, func() {
+			p := newPartition(1)
+			p.setCount(c.count)
+			owned := 0
+			for _, guid := range guids(100) {
+				if p.owns(guid) {
+					owned++
+				}
+			}
+			Expect(owned).To(Equal(c.owns))
+		})
+	}
+
+	It("owns the apps assigned to its index", func() {
+		partitions := []*partition{newPartition(0), newPartition(1), newPartition(2)}
+		for _, p := range partitions {
+			p.setCount(3)
+		}
+		for _, guid := range guids(300) {
+			owners := 0
+			for _, p := range partitions {
+				if p.owns(guid) {
+					owners++
+				}
+			}
+			Expect(owners).To(Equal(1))
+		}
+	})
+})
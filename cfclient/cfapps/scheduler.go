@@ -0,0 +1,124 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// refreshState of an app, owned by the scheduler goroutine
+type refreshState struct {
+	next    time.Time
+	backoff uint
+}
+
+// refreshScheduler spreads instance state refreshes of cached apps over
+// the refresh period. Active apps and apps with instances that are not
+// running are refreshed twice as often, idle apps back off.
+type refreshScheduler struct {
+	manager   *CFAppManager
+	period    time.Duration
+	jitter    float64
+	idle      time.Duration
+	maxPeriod time.Duration
+	states    map[string]*refreshState
+}
+
+// startRefresh schedules instance state refreshes on FIREHOSE_INSTANCE_REFRESH_PERIOD
+func (c *CFAppManager) startRefresh() {
+	s := &refreshScheduler{
+		manager:   c,
+		period:    c.app.Config.GetDuration("FIREHOSE_INSTANCE_REFRESH_PERIOD"),
+		jitter:    c.app.Config.GetFloat64("FIREHOSE_INSTANCE_REFRESH_JITTER"),
+		idle:      c.app.Config.GetDuration("FIREHOSE_INSTANCE_REFRESH_IDLE"),
+		maxPeriod: c.app.Config.GetDuration("FIREHOSE_INSTANCE_REFRESH_MAX_PERIOD"),
+		states:    map[string]*refreshState{},
+	}
+	if s.period <= 0 {
+		return
+	}
+	if s.maxPeriod < s.period {
+		s.maxPeriod = s.period
+	}
+	go func() {
+		for now := range time.NewTicker(time.Second).C {
+			s.tick(now)
+		}
+	}()
+}
+
+// tick refreshes every app that is due
+func (s *refreshScheduler) tick(now time.Time) {
+	present := map[string]bool{}
+	for _, a := range s.manager.Cache.List() {
+		present[a.GUID] = true
+		// synced apps that were never seen have no instances to refresh
+		if atomic.LoadInt32(&a.needsDetails) == 1 || a.IsDeleted() || !s.manager.Owns(a.GUID) {
+			continue
+		}
+		st, found := s.states[a.GUID]
+		if !found {
+			// first refreshes are spread over the period
+			s.states[a.GUID] = &refreshState{
+				next: now.Add(time.Duration(rand.Int63n(int64(s.period)))),
+			}
+			continue
+		}
+		if now.Before(st.next) {
+			continue
+		}
+
+		priority := s.priority(a, now)
+		st.next = now.Add(s.nextPeriod(a, st, priority, now))
+		p := PriorityLow
+		if priority {
+			p = PriorityNormal
+		}
+		// a refresh still waiting on the rate limiter is not doubled up
+		if atomic.CompareAndSwapInt32(&a.refreshing, 0, 1) {
+			go func(a *CFApp, p Priority) {
+				defer atomic.StoreInt32(&a.refreshing, 0)
+				a.updateInstances(p)
+			}(a, p)
+		}
+	}
+	for guid := range s.states {
+		if !present[guid] {
+			delete(s.states, guid)
+		}
+	}
+}
+
+// priority apps emitted container metrics within the period,
+// or have instances that are not running
+func (s *refreshScheduler) priority(a *CFApp, now time.Time) bool {
+	if now.Sub(time.Unix(0, atomic.LoadInt64(&a.lastMetric))) < s.period {
+		return true
+	}
+	return a.hasInstancesNotRunning()
+}
+
+// nextPeriod halves the period for priority apps, and doubles it for each
+// refresh of an app without envelopes for FIREHOSE_INSTANCE_REFRESH_IDLE.
+func (s *refreshScheduler) nextPeriod(a *CFApp, st *refreshState, priority bool, now time.Time) time.Duration {
+	period := s.period
+	switch {
+	case priority:
+		st.backoff = 0
+		period /= 2
+	case now.Sub(time.Unix(0, atomic.LoadInt64(&a.lastSeen))) > s.idle:
+		if period<<st.backoff < s.maxPeriod {
+			st.backoff++
+		}
+		period = period << st.backoff
+		if period > s.maxPeriod {
+			period = s.maxPeriod
+		}
+	default:
+		st.backoff = 0
+	}
+	return time.Duration(float64(period) * (1 + s.jitter*(2*rand.Float64()-1)))
+}
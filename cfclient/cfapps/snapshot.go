@@ -0,0 +1,202 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// snapshotVersion is bumped when the snapshot format changes,
+// snapshots of other versions are ignored.
+const snapshotVersion = 1
+
+// snapshot of the Cache. Bindings are not saved so credentials
+// are never written to disk, they are fetched when an app is first seen.
+type snapshot struct {
+	Version int            `json:"version"`
+	SavedAt time.Time      `json:"saved_at"`
+	Apps    []*snapshotApp `json:"apps"`
+}
+
+type snapshotApp struct {
+	GUID          string                 `json:"guid"`
+	Name          string                 `json:"name"`
+	Attributes    map[string]interface{} `json:"attributes"`
+	MetadataNames []string               `json:"metadata_names"`
+	Summaries     map[int32]string       `json:"summaries"`
+	LastPull      time.Time              `json:"last_pull"`
+}
+
+// startSnapshots saves the Cache on FIREHOSE_CACHE_SNAPSHOT_INTERVAL
+func (c *CFAppManager) startSnapshots() {
+	interval := c.app.Config.GetDuration("FIREHOSE_CACHE_SNAPSHOT_INTERVAL")
+	if len(c.snapshotFile()) == 0 || interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.NewTicker(interval).C {
+			c.SaveSnapshot()
+		}
+	}()
+}
+
+func (c *CFAppManager) snapshotFile() string {
+	return c.app.Config.GetString("FIREHOSE_CACHE_SNAPSHOT_FILE")
+}
+
+// SaveSnapshot writes the Cache to FIREHOSE_CACHE_SNAPSHOT_FILE, replacing
+// the previous snapshot only once the new one is completely written.
+func (c *CFAppManager) SaveSnapshot() {
+	path := c.snapshotFile()
+	if len(path) == 0 {
+		return
+	}
+
+	s := &snapshot{
+		Version: snapshotVersion,
+		SavedAt: time.Now(),
+	}
+	c.Cache.sync.RLock()
+	for _, a := range c.Cache.Collection {
+		if a.IsDeleted() {
+			continue
+		}
+		s.Apps = append(s.Apps, a.snapshot())
+	}
+	c.Cache.sync.RUnlock()
+
+	if err := writeSnapshot(path, s); err != nil {
+		c.app.Log.Warnf("unable to save app cache snapshot: %s", err.Error())
+		return
+	}
+	c.app.Log.Debugf("saved %d apps to %s", len(s.Apps), path)
+}
+
+func writeSnapshot(path string, s *snapshot) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	// removing fails harmlessly once the file is renamed
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(s); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot fills the Cache from FIREHOSE_CACHE_SNAPSHOT_FILE. Loaded apps
+// are stale but usable, they are refetched when first seen or by the next sync.
+func (c *CFAppManager) LoadSnapshot() {
+	path := c.snapshotFile()
+	if len(path) == 0 {
+		return
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.app.Log.Warnf("unable to read app cache snapshot: %s", err.Error())
+		}
+		return
+	}
+	s := &snapshot{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(s); err != nil {
+		c.app.Log.Warnf("unable to parse app cache snapshot: %s", err.Error())
+		return
+	}
+	if s.Version != snapshotVersion {
+		c.app.Log.Warnf("ignoring app cache snapshot version %d, expected %d", s.Version, snapshotVersion)
+		return
+	}
+
+	apps := make([]*CFApp, 0, len(s.Apps))
+	for _, sa := range s.Apps {
+		apps = append(apps, sa.restore())
+	}
+	c.Cache.PutAll(apps)
+	c.app.Log.Infof("loaded %d apps from snapshot saved at %s", len(apps), s.SavedAt.Format(time.RFC3339))
+}
+
+func (a *CFApp) snapshot() *snapshotApp {
+	a.Lock.RLock()
+	defer a.Lock.RUnlock()
+	sa := &snapshotApp{
+		GUID:          a.GUID,
+		Attributes:    a.Attributes.Marshal(),
+		MetadataNames: append([]string{}, a.metadataNames...),
+		Summaries:     map[int32]string{},
+		LastPull:      a.LastPull,
+	}
+	if a.App != nil {
+		sa.Name = a.App.Name
+	}
+	for k, v := range a.Summaries {
+		sa.Summaries[k] = v
+	}
+	return sa
+}
+
+func (sa *snapshotApp) restore() *CFApp {
+	a := NewCFApp(sa.GUID)
+	for k, v := range sa.Attributes {
+		a.Attributes.SetAttribute(k, restoreValue(v))
+	}
+	if len(sa.Name) > 0 {
+		a.App = &cfclient.App{Guid: sa.GUID, Name: sa.Name}
+	}
+	if sa.Summaries != nil {
+		a.Summaries = sa.Summaries
+	}
+	a.metadataNames = sa.MetadataNames
+	a.LastPull = sa.LastPull
+	a.markStale()
+	// bindings and fresh details are fetched once the app is seen
+	a.needsDetails = 1
+	return a
+}
+
+// restoreValue of an attribute decoded as a json.Number, whole numbers such
+// as instance counts and limits are integers again rather than float64
+func restoreValue(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// markStale flags an app loaded from a snapshot, callers hold the lock
+func (a *CFApp) markStale() {
+	a.Stale = true
+	a.Attributes.SetAttribute(AppStale, true)
+}
+
+// markFresh once the app is refreshed from the CF API, callers hold the lock
+func (a *CFApp) markFresh() {
+	a.Stale = false
+	a.Attributes.Remove(AppStale)
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("snapshot", func() {
+	var dir string
+	var saved, loaded *CFAppManager
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "snapshot")
+		Expect(err).NotTo(HaveOccurred())
+		// set in the environment, the config is read by cache goroutines
+		os.Setenv("NRF_FIREHOSE_CACHE_SNAPSHOT_FILE", filepath.Join(dir, "apps.json"))
+		saved = &CFAppManager{app: app.Get(), Cache: NewCache()}
+		loaded = &CFAppManager{app: app.Get(), Cache: NewCache()}
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("NRF_FIREHOSE_CACHE_SNAPSHOT_FILE")
+		os.RemoveAll(dir)
+	})
+
+	It("restores the attribute types", func() {
+		a := NewCFApp("guid")
+		a.Attributes.SetAttribute(AppName, "app")
+		a.Attributes.SetAttribute(AppInstancesDesired, 3)
+		a.Attributes.SetAttribute(AppMemoryLimit, int64(1073741824))
+		a.Attributes.SetAttribute("ratio", 0.5)
+		a.Attributes.SetAttribute("flag", true)
+		saved.Cache.PutAll([]*CFApp{a})
+		saved.SaveSnapshot()
+
+		loaded.LoadSnapshot()
+		r, found := loaded.Cache.Get("guid")
+		Expect(found).To(BeTrue())
+		attrs := r.Attributes.Marshal()
+		Expect(attrs).To(HaveKeyWithValue(AppName, "app"))
+		Expect(attrs).To(HaveKeyWithValue(AppInstancesDesired, int64(3)))
+		Expect(attrs).To(HaveKeyWithValue(AppMemoryLimit, int64(1073741824)))
+		Expect(attrs).To(HaveKeyWithValue("ratio", 0.5))
+		Expect(attrs).To(HaveKeyWithValue("flag", true))
+	})
+
+	It("flags loaded apps stale until refreshed", func() {
+		saved.Cache.PutAll([]*CFApp{NewCFApp("guid")})
+		saved.SaveSnapshot()
+
+		loaded.LoadSnapshot()
+		r, _ := loaded.Cache.Get("guid")
+		Expect(r.Stale).To(BeTrue())
+		Expect(r.Attributes.Marshal()).To(HaveKeyWithValue(AppStale, true))
+		Expect(r.needsDetails).To(Equal(int32(1)))
+
+		r.markFresh()
+		Expect(r.Stale).To(BeFalse())
+		Expect(r.Attributes.Marshal()).NotTo(HaveKey(AppStale))
+	})
+
+	It("skips deleted apps", func() {
+		a := NewCFApp("deleted")
+		a.markDeleted()
+		saved.Cache.PutAll([]*CFApp{a})
+		saved.SaveSnapshot()
+
+		loaded.LoadSnapshot()
+		_, found := loaded.Cache.Get("deleted")
+		Expect(found).To(BeFalse())
+	})
+
+	It("ignores other versions", func() {
+		path := app.Get().Config.GetString("FIREHOSE_CACHE_SNAPSHOT_FILE")
+		Expect(ioutil.WriteFile(path, []byte(`{"version": 0, "apps": [{"guid": "guid"}]}`), 0600)).To(Succeed())
+
+		loaded.LoadSnapshot()
+		_, found := loaded.Cache.Get("guid")
+		Expect(found).To(BeFalse())
+	})
+})
@@ -0,0 +1,298 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// v3Page of a paginated v3 list call
+type v3Page struct {
+	Pagination struct {
+		Next *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Resources []v3Resource `json:"resources"`
+	Included  v3Included   `json:"included"`
+}
+
+// v3Relationships of a to-many relationship
+type v3Relationships struct {
+	Data []struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+// segments are the isolation segment names of spaces and the default
+// isolation segment names of orgs
+type segments struct {
+	spaces map[string]string
+	orgs   map[string]string
+}
+
+// of the space, or the default of its org
+func (s *segments) of(space string, org string) string {
+	if name, found := s.spaces[space]; found {
+		return name
+	}
+	if name, found := s.orgs[org]; found {
+		return name
+	}
+	return sharedSegment
+}
+
+// startSync lists every app at startup and on FIREHOSE_CACHE_SYNC_INTERVAL,
+// updating the nozzle instance count first
+func (c *CFAppManager) startSync() {
+	interval := c.app.Config.GetDuration("FIREHOSE_CACHE_SYNC_INTERVAL")
+	if interval <= 0 {
+		atomic.StoreInt32(&c.synced, 1)
+		go c.updatePartition()
+		return
+	}
+	go func() {
+		for {
+			c.updatePartition()
+			if err := c.Sync(); err != nil {
+				c.app.Log.Warnf("app cache sync failed: %s", err.Error())
+			}
+			atomic.StoreInt32(&c.synced, 1)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Sync lists every app with its space and org, every web process, stack and
+// isolation segment, filling the Cache in one go. Apps created since the last
+// sync are still fetched individually by GetApp.
+func (c *CFAppManager) Sync() error {
+	start := time.Now()
+	pageSize := c.app.Config.GetInt("FIREHOSE_CACHE_SYNC_PAGE_SIZE")
+
+	var apps []v3Resource
+	spaces := map[string]v3Resource{}
+	orgs := map[string]v3Resource{}
+	err := c.listV3(
+		fmt.Sprintf("/v3/apps?per_page=%d&include=space.organization", pageSize),
+		func(p *v3Page) {
+			apps = append(apps, p.Resources...)
+			for _, s := range p.Included.Spaces {
+				spaces[s.GUID] = s
+			}
+			for _, o := range p.Included.Organizations {
+				orgs[o.GUID] = o
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	processes := map[string]v3Resource{}
+	err = c.listV3(
+		fmt.Sprintf("/v3/processes?per_page=%d&types=web", pageSize),
+		func(p *v3Page) {
+			for _, r := range p.Resources {
+				processes[r.linked("app")] = r
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = c.listV3(
+		fmt.Sprintf("/v3/stacks?per_page=%d", pageSize),
+		func(p *v3Page) {
+			for _, r := range p.Resources {
+				setName(r.GUID, r.Name)
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	segments, err := c.listSegments(pageSize)
+	if err != nil {
+		return err
+	}
+
+	fresh := []*CFApp{}
+	for _, r := range apps {
+		a, found := c.Cache.Get(r.GUID)
+		if !found {
+			a = NewCFApp(r.GUID)
+			// details, instance states and bindings are fetched once the app is seen
+			a.needsDetails = 1
+			fresh = append(fresh, a)
+		}
+		space := spaces[r.related("space")]
+		org := orgs[space.related("organization")]
+		a.applySync(r, space, org, processes[r.GUID], segments.of(space.GUID, org.GUID))
+	}
+	c.Cache.PutAll(fresh)
+
+	c.app.Log.Infof("app cache synced %d apps, %d new, in %s", len(apps), len(fresh), time.Since(start))
+	return nil
+}
+
+// listV3 calls fn with every page of a v3 list call
+func (c *CFAppManager) listV3(path string, fn func(p *v3Page)) error {
+	for len(path) > 0 {
+		p, err := c.getV3Page(path)
+		if err != nil {
+			if strings.Contains(err.Error(), "401 Unauthorized") {
+				//401 unauthorized -- token has expired so we need to refresh the client
+				c.app.Log.Warnf("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
+				c.UpdateClient()
+			}
+			return fmt.Errorf("CF api error %s on %s", err.Error(), path)
+		}
+		fn(p)
+		path = ""
+		if p.Pagination.Next != nil {
+			// next links are absolute, requests are relative to the API address
+			next, err := url.Parse(p.Pagination.Next.Href)
+			if err != nil {
+				return err
+			}
+			path = next.RequestURI()
+		}
+	}
+	return nil
+}
+
+func (c *CFAppManager) getV3Page(path string) (*v3Page, error) {
+	p := &v3Page{}
+	if err := c.getV3(path, PriorityNormal, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// getV3 decodes the response of a v3 call into v, waiting for the rate limiter
+func (c *CFAppManager) getV3(path string, p Priority, v interface{}) error {
+	if timeout := c.rateManager.Wait(p); timeout != nil {
+		return timeout
+	}
+	defer c.rateManager.Done()
+
+	c.clientLock.RLock()
+	defer c.clientLock.RUnlock()
+	start := time.Now()
+	resp, err := c.client.DoRequest(c.client.NewRequest("GET", path))
+	observeCall(start, err)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// listSegments lists the isolation segments with the spaces assigned to them.
+// Only orgs entitled to a segment other than shared can default to it, so
+// the default is looked up for those orgs alone.
+func (c *CFAppManager) listSegments(pageSize int) (*segments, error) {
+	var all []v3Resource
+	err := c.listV3(
+		fmt.Sprintf("/v3/isolation_segments?per_page=%d", pageSize),
+		func(p *v3Page) {
+			all = append(all, p.Resources...)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &segments{spaces: map[string]string{}, orgs: map[string]string{}}
+	entitled := map[string]bool{}
+	for _, segment := range all {
+		setName(segment.GUID, segment.Name)
+		spaces := &v3Relationships{}
+		path := fmt.Sprintf("/v3/isolation_segments/%s/relationships/spaces", segment.GUID)
+		if err := c.getV3(path, PriorityNormal, spaces); err != nil {
+			return nil, fmt.Errorf("CF api error %s on %s", err.Error(), path)
+		}
+		for _, space := range spaces.Data {
+			s.spaces[space.GUID] = segment.Name
+		}
+		if segment.Name == sharedSegment {
+			continue
+		}
+		orgs := &v3Relationships{}
+		path = fmt.Sprintf("/v3/isolation_segments/%s/relationships/organizations", segment.GUID)
+		if err := c.getV3(path, PriorityNormal, orgs); err != nil {
+			return nil, fmt.Errorf("CF api error %s on %s", err.Error(), path)
+		}
+		for _, org := range orgs.Data {
+			entitled[org.GUID] = true
+		}
+	}
+
+	for guid := range entitled {
+		def := &v3Relationship{}
+		path := fmt.Sprintf("/v3/organizations/%s/relationships/default_isolation_segment", guid)
+		if err := c.getV3(path, PriorityNormal, def); err != nil {
+			return nil, fmt.Errorf("CF api error %s on %s", err.Error(), path)
+		}
+		if len(def.Data.GUID) > 0 {
+			s.orgs[guid] = c.resourceName("isolation_segments", def.Data.GUID)
+		}
+	}
+	return s, nil
+}
+
+// applySync updates the app from the v3 list calls
+func (a *CFApp) applySync(r v3Resource, space v3Resource, org v3Resource, process v3Resource, segment string) {
+	a.Lock.Lock()
+	defer a.Lock.Unlock()
+
+	if a.App == nil {
+		a.App = &cfclient.App{}
+	}
+	a.App.Guid = r.GUID
+	a.App.Name = r.Name
+	a.App.State = r.State
+	a.App.Instances = process.Instances
+	a.App.SpaceGuid = space.GUID
+	a.markFresh()
+
+	a.Attributes.SetAttribute(AppInstancesDesired, process.Instances)
+	a.Attributes.SetAttribute(AppName, r.Name)
+	a.Attributes.SetAttribute(AppSpaceName, space.Name)
+	a.Attributes.SetAttribute(AppOrgName, org.Name)
+	a.setSyncDetails(r, space, process, segment)
+	if MetadataEnabled() {
+		a.setMetadata(r, space, org)
+	}
+
+	a.LastPull = time.Now()
+}
+
+// requestDetails fetches the instance states and bindings the first time a
+// synced app is seen. Apps loaded from a snapshot wait for the first sync, and
+// are fetched individually when it did not list them.
+func (c *CFAppManager) requestDetails(a *CFApp) {
+	if atomic.LoadInt32(&c.synced) == 0 || !atomic.CompareAndSwapInt32(&a.needsDetails, 1, 0) {
+		return
+	}
+	a.Lock.Lock()
+	stale := a.Stale
+	if !stale {
+		c.updateDetails(a, false)
+	}
+	a.Lock.Unlock()
+	if stale {
+		c.updateAppAsync(a)
+	}
+}
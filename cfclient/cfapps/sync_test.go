@@ -0,0 +1,74 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const processesPage = `{
+  "pagination": {"next": null},
+  "resources": [
+    {
+      "guid": "6a901b7c-186f-4677-b2b5-b9d1a6f1d6a4",
+      "type": "web",
+      "instances": 3,
+      "relationships": {"revision": {"data": {"guid": "885735b5-aea4-4cf5-8e44-961af0e41920"}}},
+      "links": {"app": {"href": "https://api.example.com/v3/apps/ccc25a0f-c8f4-4b39-9f1b-de9f328d0ee5"}}
+    }
+  ]
+}`
+
+var _ = Describe("linked", func() {
+	for _, c := range []struct {
+		name  string
+		links map[string]v3Link
+		guid  string
+	}{
+		{"takes the last path segment", map[string]v3Link{"app": {"https://api.example.com/v3/apps/abc"}}, "abc"},
+		{"ignores a trailing slash", map[string]v3Link{"app": {"https://api.example.com/v3/apps/abc/"}}, "abc"},
+		{"is empty without the link", map[string]v3Link{"space": {"https://api.example.com/v3/spaces/def"}}, ""},
+		{"is empty without links", nil, ""},
+	} {
+		c := c
+		It(c.name, func() {
+			Expect(v3Resource{Links: c.links}.linked("app")).To(Equal(c.guid))
+		})
+	}
+
+	It("finds the app of a v3 process", func() {
+		p := &v3Page{}
+		Expect(json.Unmarshal([]byte(processesPage), p)).To(Succeed())
+		Expect(p.Resources).To(HaveLen(1))
+		Expect(p.Resources[0].related("app")).To(BeEmpty())
+		Expect(p.Resources[0].linked("app")).To(Equal("ccc25a0f-c8f4-4b39-9f1b-de9f328d0ee5"))
+		Expect(p.Resources[0].Instances).To(Equal(3))
+	})
+})
+
+var _ = Describe("segments", func() {
+	s := &segments{
+		spaces: map[string]string{"space-iso": "iso", "space-shared": "shared"},
+		orgs:   map[string]string{"org-iso": "iso"},
+	}
+	for _, c := range []struct {
+		name    string
+		space   string
+		org     string
+		segment string
+	}{
+		{"uses the segment of the space", "space-iso", "org", "iso"},
+		{"prefers the space over the org default", "space-shared", "org-iso", "shared"},
+		{"falls back to the org default", "space", "org-iso", "iso"},
+		{"is shared without either", "space", "org", "shared"},
+	} {
+		c := c
+		It(c.name, func() {
+			Expect(s.of(c.space, c.org)).To(Equal(c.segment))
+		})
+	}
+})
@@ -0,0 +1,57 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"strings"
+	"time"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+)
+
+// isNotFound reports CF API 404 responses, v2 errors carry the app not found
+// code, v3 errors the resource not found code, others only the HTTP status.
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	return cfclient.IsAppNotFoundError(err) ||
+		cfclient.IsResourceNotFoundError(err) ||
+		strings.Contains(err.Error(), "404 Not Found") ||
+		strings.Contains(err.Error(), "CF-AppNotFound")
+}
+
+// markDeleted tombstones an app the CF API no longer knows. Deleted apps are
+// not refreshed, events carry app.deleted and the app is evicted once
+// FIREHOSE_CACHE_DELETED_GRACE has passed. Callers hold the lock.
+func (a *CFApp) markDeleted() {
+	if a.Deleted {
+		return
+	}
+	a.Deleted = true
+	a.DeletedAt = time.Now()
+	a.Attributes.SetAttribute(AppDeleted, true)
+	Removed(a.GUID)
+	app.Get().Log.Infof("app %s was deleted, evicting after %s", a.GUID, app.Get().Config.GetDuration("FIREHOSE_CACHE_DELETED_GRACE"))
+}
+
+// IsDeleted ...
+func (a *CFApp) IsDeleted() bool {
+	a.Lock.RLock()
+	defer a.Lock.RUnlock()
+	return a.Deleted
+}
+
+// evictDeleted removes apps deleted longer than the grace period ago
+func (c *Cache) evictDeleted(grace time.Duration) {
+	for _, a := range c.List() {
+		a.Lock.RLock()
+		expired := a.Deleted && time.Since(a.DeletedAt) > grace
+		a.Lock.RUnlock()
+		if expired {
+			c.Delete(a.GUID)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package credhub resolves CredHub references in service binding credentials.
+// The CF API returns credentials stored in CredHub as {"credhub-ref": "<name>"},
+// their values are read from the CredHub API with a UAA client token.
+package credhub
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/api"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+)
+
+// RefKey holds the CredHub name of credentials stored in CredHub
+const RefKey = "credhub-ref"
+
+// TokenFunc returns an Authorization header value, such as "bearer <token>"
+type TokenFunc func() (string, error)
+
+// Client of the CredHub API
+type Client struct {
+	URL        string
+	Token      TokenFunc
+	HTTPClient *http.Client
+	TTL        time.Duration
+	lock       sync.Mutex
+	token      string
+	cache      map[string]*cached
+}
+
+type cached struct {
+	value   map[string]interface{}
+	expires time.Time
+}
+
+type dataResponse struct {
+	Data []struct {
+		Name  string          `json:"name"`
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	} `json:"data"`
+}
+
+// New Client for a CredHub URL and token source
+func New(credhubURL string, token TokenFunc, ttl time.Duration) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(credhubURL, "/"),
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+		TTL:        ttl,
+		cache:      map[string]*cached{},
+	}
+}
+
+// FromConfig returns a Client authenticated with CREDHUB_CLIENT_ID against
+// the UAA, or nil when CREDHUB_URL is not set.
+func FromConfig(c *config.Config) (*Client, error) {
+	if len(c.GetString("CREDHUB_URL")) == 0 {
+		return nil, nil
+	}
+	uaa, err := api.NewUAATokenRefresher(
+		c.GetString("CF_API_UAA_URL"),
+		c.GetString("CREDHUB_CLIENT_ID"),
+		c.GetString("CREDHUB_CLIENT_SECRET"),
+		c.GetBool("CF_SKIP_SSL"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	client := New(c.GetString("CREDHUB_URL"), uaa.RefreshAuthToken, c.GetDuration("CREDHUB_CACHE_TTL"))
+	if c.GetBool("CF_SKIP_SSL") {
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+	return client, nil
+}
+
+// Get the current value of a JSON credential by name
+func (c *Client) Get(name string) (map[string]interface{}, error) {
+	c.lock.Lock()
+	if v, found := c.cache[name]; found && time.Now().Before(v.expires) {
+		c.lock.Unlock()
+		return v.value, nil
+	}
+	c.lock.Unlock()
+
+	res, err := c.get(name, false)
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		// the token expired, fetch a new one once
+		res.Body.Close()
+		res, err = c.get(name, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("credhub %s: %s", name, res.Status)
+	}
+
+	data := &dataResponse{}
+	if err := json.NewDecoder(res.Body).Decode(data); err != nil {
+		return nil, fmt.Errorf("credhub %s: %s", name, err.Error())
+	}
+	if len(data.Data) == 0 {
+		return nil, fmt.Errorf("credhub %s: no value", name)
+	}
+	value := map[string]interface{}{}
+	if err := json.Unmarshal(data.Data[0].Value, &value); err != nil {
+		return nil, fmt.Errorf("credhub %s: %s value is not JSON", name, data.Data[0].Type)
+	}
+
+	c.lock.Lock()
+	c.cache[name] = &cached{value: value, expires: time.Now().Add(c.TTL)}
+	c.lock.Unlock()
+	return value, nil
+}
+
+func (c *Client) get(name string, refresh bool) (*http.Response, error) {
+	token, err := c.authorization(refresh)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", c.URL+"/api/v1/data?current=true&name="+url.QueryEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", token)
+	return c.HTTPClient.Do(req)
+}
+
+func (c *Client) authorization(refresh bool) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.token) == 0 || refresh {
+		token, err := c.Token()
+		if err != nil {
+			return "", err
+		}
+		c.token = token
+	}
+	return c.token, nil
+}
+
+// Resolve replaces CredHub references in the credentials of VCAP_SERVICES,
+// references that can not be read are left in place and returned as an error.
+func (c *Client) Resolve(services map[string]interface{}) error {
+	var failed []string
+	for _, list := range services {
+		bindings, ok := list.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, b := range bindings {
+			binding, ok := b.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			credentials, ok := binding["credentials"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			ref, ok := credentials[RefKey].(string)
+			if !ok {
+				continue
+			}
+			value, err := c.Get(ref)
+			if err != nil {
+				failed = append(failed, err.Error())
+				continue
+			}
+			binding["credentials"] = value
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to resolve credentials: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
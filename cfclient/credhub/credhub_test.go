@@ -0,0 +1,129 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package credhub_test
+
+import (
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/credhub"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/credhub/credhubtest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var credentials = map[string]interface{}{
+	"insightsInsertKey": "key",
+	"rpmAccountId":      "123",
+}
+
+var _ = Describe("Client", func() {
+	var server *credhubtest.Server
+
+	BeforeEach(func() {
+		server = credhubtest.NewServer(map[string]map[string]interface{}{
+			"/c/newrelic/nr/binding/credentials": credentials,
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Get", func() {
+		It("reads the current value", func() {
+			c := credhub.New(server.URL+"/", credhubtest.TokenFunc, time.Minute)
+			Expect(c.Get("/c/newrelic/nr/binding/credentials")).To(Equal(credentials))
+		})
+
+		It("caches values for the TTL", func() {
+			c := credhub.New(server.URL, credhubtest.TokenFunc, time.Minute)
+			for i := 0; i < 3; i++ {
+				_, err := c.Get("/c/newrelic/nr/binding/credentials")
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(server.Requests()).To(Equal(1))
+		})
+
+		It("reads values again once expired", func() {
+			c := credhub.New(server.URL, credhubtest.TokenFunc, 0)
+			_, err := c.Get("/c/newrelic/nr/binding/credentials")
+			Expect(err).NotTo(HaveOccurred())
+			server.Set("/c/newrelic/nr/binding/credentials", map[string]interface{}{"rpmAccountId": "456"})
+			Expect(c.Get("/c/newrelic/nr/binding/credentials")).To(HaveKeyWithValue("rpmAccountId", "456"))
+			Expect(server.Requests()).To(Equal(2))
+		})
+
+		It("refreshes an expired token once", func() {
+			tokens := []string{"bearer expired", credhubtest.Token}
+			calls := 0
+			c := credhub.New(server.URL, func() (string, error) {
+				calls++
+				return tokens[calls-1], nil
+			}, time.Minute)
+			Expect(c.Get("/c/newrelic/nr/binding/credentials")).To(Equal(credentials))
+			Expect(calls).To(Equal(2))
+			Expect(server.Requests()).To(Equal(2))
+		})
+
+		It("fails when the token is refused again", func() {
+			c := credhub.New(server.URL, func() (string, error) { return "bearer expired", nil }, time.Minute)
+			_, err := c.Get("/c/newrelic/nr/binding/credentials")
+			Expect(err).To(MatchError(ContainSubstring("401")))
+			Expect(server.Requests()).To(Equal(2))
+		})
+
+		It("fails on unknown credentials", func() {
+			c := credhub.New(server.URL, credhubtest.TokenFunc, time.Minute)
+			_, err := c.Get("/c/unknown")
+			Expect(err).To(MatchError(ContainSubstring("404")))
+		})
+	})
+
+	Describe("Resolve", func() {
+		binding := func(credentials map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"name": "newrelic", "credentials": credentials}
+		}
+
+		It("replaces references with their values", func() {
+			c := credhub.New(server.URL, credhubtest.TokenFunc, time.Minute)
+			plain := map[string]interface{}{"rpmAccountId": "789"}
+			services := map[string]interface{}{
+				"newrelic": []interface{}{
+					binding(map[string]interface{}{credhub.RefKey: "/c/newrelic/nr/binding/credentials"}),
+					binding(plain),
+				},
+			}
+			Expect(c.Resolve(services)).To(Succeed())
+			bindings := services["newrelic"].([]interface{})
+			Expect(bindings[0].(map[string]interface{})["credentials"]).To(Equal(credentials))
+			Expect(bindings[1].(map[string]interface{})["credentials"]).To(Equal(plain))
+		})
+
+		It("leaves references it can not read", func() {
+			c := credhub.New(server.URL, credhubtest.TokenFunc, time.Minute)
+			ref := map[string]interface{}{credhub.RefKey: "/c/unknown"}
+			services := map[string]interface{}{
+				"newrelic": []interface{}{
+					binding(ref),
+					binding(map[string]interface{}{credhub.RefKey: "/c/newrelic/nr/binding/credentials"}),
+				},
+			}
+			Expect(c.Resolve(services)).To(MatchError(ContainSubstring("credhub /c/unknown")))
+			bindings := services["newrelic"].([]interface{})
+			Expect(bindings[0].(map[string]interface{})["credentials"]).To(Equal(ref))
+			Expect(bindings[1].(map[string]interface{})["credentials"]).To(Equal(credentials))
+		})
+
+		It("skips malformed services", func() {
+			c := credhub.New(server.URL, credhubtest.TokenFunc, time.Minute)
+			services := map[string]interface{}{
+				"newrelic": "not a list",
+				"other":    []interface{}{"not a binding", map[string]interface{}{"credentials": "none"}},
+			}
+			Expect(c.Resolve(services)).To(Succeed())
+			Expect(server.Requests()).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,90 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package credhubtest emulates the CredHub data API, for tests and for
+// running the nozzle locally against bindings with CredHub references.
+package credhubtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Token the stand-in accepts, as the Authorization header value
+const Token = "bearer credhubtest"
+
+// Server serving JSON credentials by name
+type Server struct {
+	*httptest.Server
+	lock        sync.Mutex
+	credentials map[string]map[string]interface{}
+	requests    int
+}
+
+// NewServer starts a stand-in with the given credentials, Close it when done
+func NewServer(credentials map[string]map[string]interface{}) *Server {
+	s := &Server{credentials: map[string]map[string]interface{}{}}
+	for name, value := range credentials {
+		s.Set(name, value)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// TokenFunc for a credhub.Client of the stand-in
+func TokenFunc() (string, error) {
+	return Token, nil
+}
+
+// Set the value of a credential
+func (s *Server) Set(name string, value map[string]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.credentials[name] = value
+}
+
+// Requests served, including failed ones
+func (s *Server) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+
+	if r.Header.Get("Authorization") != Token {
+		writeError(w, http.StatusUnauthorized, "Full authentication is required to access this resource")
+		return
+	}
+	if r.Method != "GET" || r.URL.Path != "/api/v1/data" {
+		writeError(w, http.StatusNotFound, "The request could not be completed because the resource does not exist.")
+		return
+	}
+	name := r.URL.Query().Get("name")
+	value, found := s.credentials[name]
+	if !found {
+		writeError(w, http.StatusNotFound, "The request could not be completed because the credential does not exist or you do not have sufficient authorization.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": []interface{}{
+			map[string]interface{}{
+				"type":  "json",
+				"name":  name,
+				"value": value,
+			},
+		},
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
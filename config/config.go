@@ -79,16 +79,67 @@ func set() *Config {
 	v.SetDefault("CF_SKIP_SSL", true)
 
 	v.SetDefault("HEALTH_PORT", 8080)
+	// /health/ready fails when no envelope was received for this long,
+	// or when this many NEWRELIC_DRAIN_INTERVAL harvests were missed. 0 disables.
+	v.SetDefault("HEALTH_MAX_ENVELOPE_AGE", "5m")
+	v.SetDefault("HEALTH_HARVEST_INTERVALS", 5)
 
 	// Cache purge threshold in minutes
 	v.SetDefault("FIREHOSE_CACHE_DURATION_MINS", 30)
-	// Rate limiter burst limit
+	// Apps the CF API reports as not found are kept, flagged as deleted, for this long.
+	v.SetDefault("FIREHOSE_CACHE_DELETED_GRACE", "5m")
+	// Bulk sync of all apps, spaces and orgs at startup and on this interval, disabled when 0.
+	v.SetDefault("FIREHOSE_CACHE_SYNC_INTERVAL", "10m")
+	// Page size of the CF API v3 list calls used by the bulk sync.
+	v.SetDefault("FIREHOSE_CACHE_SYNC_PAGE_SIZE", 5000)
+	// Poll CF API audit events on this interval to refresh or evict changed apps, disabled when 0.
+	v.SetDefault("FIREHOSE_CACHE_AUDIT_INTERVAL", "15s")
+	// Audit event types that refresh a cached app - | separated values. audit.app.delete-request evicts it.
+	v.SetDefault("FIREHOSE_CACHE_AUDIT_TYPES", "audit.app.update|audit.app.delete-request|audit.app.restage|audit.app.start|audit.app.stop|audit.app.restart|audit.app.process.scale|audit.app.droplet.mapped")
+	// Snapshot the app cache to this file for warm restarts, disabled when empty.
+	v.SetDefault("FIREHOSE_CACHE_SNAPSHOT_FILE", "")
+	// Interval between snapshots, a final snapshot is saved at shutdown.
+	v.SetDefault("FIREHOSE_CACHE_SNAPSHOT_INTERVAL", "5m")
+	// Instance states of cached apps are refreshed over this period, disabled when 0.
+	// Active apps and apps with instances not running are refreshed twice as often.
+	v.SetDefault("FIREHOSE_INSTANCE_REFRESH_PERIOD", "60s")
+	// Random variation of each refresh period, as a share of the period.
+	v.SetDefault("FIREHOSE_INSTANCE_REFRESH_JITTER", 0.2)
+	// Apps without envelopes for this long back off, doubling the period up to the max period.
+	v.SetDefault("FIREHOSE_INSTANCE_REFRESH_IDLE", "10m")
+	v.SetDefault("FIREHOSE_INSTANCE_REFRESH_MAX_PERIOD", "30m")
+	// Only the nozzle instance owning an app refreshes it periodically, owners are
+	// assigned by hashing app GUIDs across instances.
+	v.SetDefault("FIREHOSE_PARTITION_REFRESHES", true)
+	// Number of nozzle instances, read from the CF API when 0.
+	v.SetDefault("FIREHOSE_INSTANCE_COUNT", 0)
+	// Rate limiter burst limit, the number of concurrent CF API calls
 	v.SetDefault("FIREHOSE_RATE_BURST", 5)
+	// CF API calls per second, unlimited when 0.
+	v.SetDefault("FIREHOSE_RATE_QPS", 20)
+	// Calls allowed at once after an idle period, defaults to FIREHOSE_RATE_QPS.
+	v.SetDefault("FIREHOSE_RATE_QPS_BURST", 0)
 	// Rate limiter timeout in seconds.
 	v.SetDefault("FIREHOSE_RATE_TIMEOUT_SECS", 30)
 
 	v.BindEnv("NEWRELIC_INSERT_KEY")
 	v.BindEnv("NEWRELIC_ACCOUNT_ID")
+	// Routes of apps to named accounts by org, space or labels, checked before
+	// service binding credentials. Inline YAML or JSON, ACCOUNT_ROUTES_FILE reads it from a file.
+	v.SetDefault("ACCOUNT_ROUTES", "")
+	v.SetDefault("ACCOUNT_ROUTES_FILE", "")
+	// Service labels whose bindings hold account credentials, in order of preference - | separated.
+	v.SetDefault("ACCOUNT_SERVICE_LABELS", "newrelic")
+	// user-provided services are only used when their name matches this expression.
+	v.SetDefault("ACCOUNT_SERVICE_NAME_PATTERN", "(?i)newrelic")
+
+	// CredHub API resolving credhub-ref binding credentials, disabled when empty.
+	v.SetDefault("CREDHUB_URL", "")
+	// UAA client with credhub.read, the token is requested from CF_API_UAA_URL.
+	v.SetDefault("CREDHUB_CLIENT_ID", "")
+	v.SetDefault("CREDHUB_CLIENT_SECRET", "")
+	// How long resolved credentials are cached.
+	v.SetDefault("CREDHUB_CACHE_TTL", "10m")
 
 	v.SetDefault("LOG_LEVEL", "INFO")
 	v.SetDefault("TRACER", false)
@@ -98,13 +149,46 @@ func set() *Config {
 	v.SetDefault("FIREHOSE_HTTP_TIMEOUT_MINS", 20)
 	v.SetDefault("FIREHOSE_RESTART_THRESH_SECS", 15)
 	v.SetDefault("NEWRELIC_DRAIN_INTERVAL", "59s")
+	// Deadline of the final harvest and flush on shutdown, kept under the 10s CF stop timeout.
+	v.SetDefault("SHUTDOWN_TIMEOUT", "8s")
 	v.SetDefault("NEWRELIC_ENQUEUE_TIMEOUT", "1s")
+	// Events posted per request, and events queued per insert key before new ones are dropped.
+	v.SetDefault("NEWRELIC_BATCH_SIZE", 950)
+	v.SetDefault("NEWRELIC_QUEUE_SIZE", 100000)
+	// Event API limits: compressed and uncompressed bytes per post, attributes per event,
+	// attribute name and string value length. Longer values are truncated, other attributes dropped.
+	v.SetDefault("NEWRELIC_MAX_PAYLOAD_BYTES", 1000000)
+	v.SetDefault("NEWRELIC_MAX_UNCOMPRESSED_BYTES", 10000000)
+	v.SetDefault("NEWRELIC_MAX_ATTRIBUTES", 255)
+	v.SetDefault("NEWRELIC_MAX_ATTRIBUTE_NAME_LENGTH", 255)
+	v.SetDefault("NEWRELIC_MAX_ATTRIBUTE_VALUE_LENGTH", 4096)
+	// Insert clients of bound or routed accounts are closed when unused this long, 0 to keep them.
+	v.SetDefault("NEWRELIC_CLIENT_IDLE_TTL", "30m")
+	// Directory spooling batches that failed to post for replay, disabled when empty.
+	v.SetDefault("NEWRELIC_SPOOL_DIR", "")
+	// Spool size cap per insert key and segment size, the oldest segments are dropped first.
+	v.SetDefault("NEWRELIC_SPOOL_MAX_SIZE_MB", 256)
+	v.SetDefault("NEWRELIC_SPOOL_SEGMENT_SIZE_MB", 4)
+	// Delay before retrying the spool, doubled up to the max after each failure.
+	v.SetDefault("NEWRELIC_SPOOL_RETRY_MIN", "5s")
+	v.SetDefault("NEWRELIC_SPOOL_RETRY_MAX", "5m")
+	// Consecutive 403 or 5xx responses opening the breaker of an account (0 to disable),
+	// how long it stays open, and whether its events fall back to the default account or are dropped.
+	v.SetDefault("NEWRELIC_BREAKER_FAILURES", 5)
+	v.SetDefault("NEWRELIC_BREAKER_RESET", "5m")
+	v.SetDefault("NEWRELIC_BREAKER_ACTION", "fallback")
+	// Report the nozzle's own throughput, drops and latencies each harvest as PCFNozzleHealth events.
+	v.SetDefault("NEWRELIC_HEALTH_EVENTS", true)
 
 	v.SetDefault(NewRelicEventTypeContainer, "PCFContainerMetric")
 	v.SetDefault(NewRelicEventTypeValueMetric, "PCFValueMetric")
 	v.SetDefault(NewRelicEventTypeCounterEvent, "PCFCounterEvent")
 	v.SetDefault(NewRelicEventTypeLogMessage, "PCFLogMessage")
 	v.SetDefault(NewRelicEventTypeHTTPStartStop, "PCFHttpStartStop")
+	v.SetDefault(NewRelicEventTypeLogThrottle, "PCFLogThrottle")
+	v.SetDefault(NewRelicEventTypeLogMetric, "PCFLogMetric")
+	v.SetDefault(NewRelicEventTypeAccountError, "PCFNozzleAccountError")
+	v.SetDefault(NewRelicEventTypeHealth, "PCFNozzleHealth")
 
 	v.SetDefault("ATTR_PREFIX", "pcf")
 	v.SetDefault(EnvEnvelopeType, "envelope.type")
@@ -123,14 +207,68 @@ func set() *Config {
 	v.SetDefault(EnvAppInstanceState, "app.instance.state")
 	v.SetDefault(EnvAppInstanceUID, "app.instance.uid")
 	v.SetDefault(EnvAppInstancesDesired, "app.instances.desired")
+	v.SetDefault(EnvAppOrgID, "app.org.id")
+	v.SetDefault(EnvAppSpaceID, "app.space.id")
+	v.SetDefault(EnvAppBuildpack, "app.buildpack")
+	v.SetDefault(EnvAppDetectedBuildpack, "app.detected.buildpack")
+	v.SetDefault(EnvAppStack, "app.stack")
+	v.SetDefault(EnvAppMemoryLimit, "app.memory.limit")
+	v.SetDefault(EnvAppDiskLimit, "app.disk.limit")
+	v.SetDefault(EnvAppHealthCheckType, "app.health.check.type")
+	v.SetDefault(EnvAppState, "app.state")
+	v.SetDefault(EnvAppIsolationSegment, "app.isolation.segment")
+	v.SetDefault(EnvAppDeleted, "app.deleted")
+	v.SetDefault(EnvAppStale, "app.stale")
 	v.SetDefault(EnvAppRpmId, "app.rpm.id")
 	v.SetDefault(EnvAppInsertKey, "app.insert.key")
 
+	// CF API v3 label and annotation keys added to app events as app.label.<key>,
+	// space.label.<key> and org.label.<key> - | separated values, * for every key.
+	v.SetDefault("METADATA_LABELS", "")
+	v.SetDefault("METADATA_ANNOTATIONS", "")
+
+	// Forward log messages as PCFLogMessage events. Log metrics are counted either way.
+	v.SetDefault("LOGMESSAGE_EVENTS_ENABLED", true)
+	// Named patterns counted per app instance as PCFLogMetric events (YAML or JSON, or a path in the _FILE setting).
+	v.SetDefault("LOGMETRIC_PATTERNS", "")
+	v.SetDefault("LOGMETRIC_PATTERNS_FILE", "")
+
 	// Filtering capabilities for log message events - , or | separated values
 	v.SetDefault("LOGMESSAGE_SOURCE_INCLUDE", "")
 	v.SetDefault("LOGMESSAGE_SOURCE_EXCLUDE", "")
 	v.SetDefault("LOGMESSAGE_MESSAGE_INCLUDE", "")
 	v.SetDefault("LOGMESSAGE_MESSAGE_EXCLUDE", "")
+	// Ordered keep/drop/sample rules for log message events, inline YAML or JSON; LOGMESSAGE_RULES_FILE for a path.
+	v.SetDefault("LOGMESSAGE_RULES", "")
+	v.SetDefault("LOGMESSAGE_RULES_FILE", "")
+
+	// Parse gorouter access log lines into rtr.* attributes, optionally dropping the raw message.
+	v.SetDefault("LOGMESSAGE_RTR_PARSE", false)
+	v.SetDefault("LOGMESSAGE_RTR_DROP_RAW", false)
+
+	// Messages over 4K are truncated, or split into linked chunk events when set to chunk.
+	v.SetDefault("LOGMESSAGE_OVERSIZE_MODE", "truncate")
+	// Maximum chunk events per message, the last chunk is truncated beyond that. Values below 1 are read as 1.
+	v.SetDefault("LOGMESSAGE_CHUNK_MAX", 16)
+
+	// Per app log rate limiting in lines per second, disabled when 0.
+	v.SetDefault("LOGMESSAGE_RATE_LIMIT", 0)
+	// Bucket size in lines, defaults to the rate limit when lower.
+	v.SetDefault("LOGMESSAGE_RATE_BURST", 0)
+	// Rate limit per app and source type rather than per app.
+	v.SetDefault("LOGMESSAGE_RATE_PER_SOURCE", false)
+	// Share of each bucket only ERR lines may use.
+	v.SetDefault("LOGMESSAGE_RATE_ERR_RESERVE", 0.2)
+	// Per app overrides - <app guid>[/<source type>]=<rate>[:<burst>], | separated values
+	v.SetDefault("LOGMESSAGE_RATE_OVERRIDES", "")
+
+	// Redaction of personal data and secrets in log messages and HTTP events.
+	v.SetDefault("REDACT_ENABLED", false)
+	// Built-in detectors - | separated values.
+	v.SetDefault("REDACT_DETECTORS", "email|jwt|bearer|card|aws|aws_secret")
+	// User defined mask rules as YAML or JSON - use REDACT_RULES_FILE for a path.
+	v.SetDefault("REDACT_RULES", "")
+	v.SetDefault("REDACT_RULES_FILE", "")
 
 	// Filtering capabilities for envelope types - | separated values.
 	// By default, all message types are enabled.  User configurations will override this behavior.
@@ -23,6 +23,18 @@ const (
 	EnvAppInstanceUID              = "ATTR_APP_INSTANCE_UID"
 	EnvAppInstanceState            = "ATTR_APP_INSTANCE_STATE"
 	EnvAppInstancesDesired         = "ATTR_APP_INSTANCES_DESIRED"
+	EnvAppOrgID                    = "ATTR_APP_ORG_ID"
+	EnvAppSpaceID                  = "ATTR_APP_SPACE_ID"
+	EnvAppBuildpack                = "ATTR_APP_BUILDPACK"
+	EnvAppDetectedBuildpack        = "ATTR_APP_DETECTED_BUILDPACK"
+	EnvAppStack                    = "ATTR_APP_STACK"
+	EnvAppMemoryLimit              = "ATTR_APP_MEMORY_LIMIT"
+	EnvAppDiskLimit                = "ATTR_APP_DISK_LIMIT"
+	EnvAppHealthCheckType          = "ATTR_APP_HEALTH_CHECK_TYPE"
+	EnvAppState                    = "ATTR_APP_STATE"
+	EnvAppIsolationSegment         = "ATTR_APP_ISOLATION_SEGMENT"
+	EnvAppDeleted                  = "ATTR_APP_DELETED"
+	EnvAppStale                    = "ATTR_APP_STALE"
 	EnvAppRpmId                    = "ATTR_APP_RPM_ID"
 	EnvAppInsertKey                = "ATTR_APP_INSERT_KEY"
 	NewRelicEventTypeContainer     = "NEWRELIC_EVENT_TYPE_CONTAINER"
@@ -30,4 +42,8 @@ const (
 	NewRelicEventTypeCounterEvent  = "NEWRELIC_EVENT_TYPE_COUNTER"
 	NewRelicEventTypeLogMessage    = "NEWRELIC_EVENT_TYPE_LOG"
 	NewRelicEventTypeHTTPStartStop = "NEWRELIC_EVENT_TYPE_HTTPSTARTSTOP"
+	NewRelicEventTypeLogThrottle   = "NEWRELIC_EVENT_TYPE_LOG_THROTTLE"
+	NewRelicEventTypeLogMetric     = "NEWRELIC_EVENT_TYPE_LOG_METRIC"
+	NewRelicEventTypeAccountError  = "NEWRELIC_EVENT_TYPE_ACCOUNT_ERROR"
+	NewRelicEventTypeHealth        = "NEWRELIC_EVENT_TYPE_HEALTH"
 )
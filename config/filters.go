@@ -4,6 +4,7 @@
 package config
 
 import (
+	"io/ioutil"
 	"strings"
 )
 
@@ -18,3 +19,12 @@ func (c *Config) GetFilter(filterName string) []string {
 	}
 	return strings.Split(c.GetString(filterName), ",")
 }
+
+// GetDocument retrieves a YAML or JSON document setting, read from the file named
+// by the same setting suffixed with _FILE when that is set.
+func (c *Config) GetDocument(name string) ([]byte, error) {
+	if path := c.GetString(name + "_FILE"); len(path) > 0 {
+		return ioutil.ReadFile(path)
+	}
+	return []byte(strings.TrimSpace(c.GetString(name))), nil
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"sync/atomic"
+	"time"
 
 	"github.com/cloudfoundry/go-loggregator"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose/httpfirehose"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/logger"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/telemetry"
 )
 
 // Firehose Object...
@@ -27,15 +29,19 @@ type Firehose struct {
 	nozzle     *loggregator.RLPGatewayClient
 	eventsChan chan *loggregator_v2.Envelope
 	closeChan  chan bool
+	stopped    chan struct{}
 	Queue      *OneToOneEnvelope
 	EventCount int64
-	cancel     context.CancelFunc
+	// lastEnvelope is when the last envelope was received, in Unix nanoseconds
+	lastEnvelope int64
+	cancel       context.CancelFunc
 }
 
-// Close Firehose
+// Close Firehose, returning once received envelopes are in the Queue
 func (f *Firehose) Close() {
 	f.cancel()
 	f.closeChan <- true
+	<-f.stopped
 	f.log.Info("closed firehose consumer")
 }
 
@@ -49,16 +55,24 @@ func (f *Firehose) ResetEventCount() {
 	atomic.StoreInt64(&f.EventCount, 0)
 }
 
+// LastEnvelope returns when the last envelope was received, or when the
+// Firehose started if none was
+func (f *Firehose) LastEnvelope() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&f.lastEnvelope))
+}
+
 // Start New Firehose
 func Start() *Firehose {
 
 	var errorChan <-chan error
 
 	f := &Firehose{
-		log:        app.Get().Log,
-		config:     app.Get().Config,
-		eventsChan: make(chan *loggregator_v2.Envelope, 1024),
-		closeChan:  make(chan bool),
+		log:          app.Get().Log,
+		config:       app.Get().Config,
+		eventsChan:   make(chan *loggregator_v2.Envelope, 1024),
+		closeChan:    make(chan bool),
+		stopped:      make(chan struct{}),
+		lastEnvelope: time.Now().UnixNano(),
 	}
 
 	f.log.Info("starting firehose")
@@ -89,6 +103,7 @@ func Start() *Firehose {
 	f.Queue = NewOneToOneEnvelope(
 		f.config.GetInt("FIREHOSE_DIODE_BUFFER"),
 		diodes.AlertFunc(func(missed int) {
+			telemetry.Count("firehose.diode.dropped", int64(missed))
 			f.log.Warnf("Firehose diode dropped %d messages", missed)
 		}))
 
@@ -104,12 +119,22 @@ func Start() *Firehose {
 				app.Get().ErrorChan <- err
 
 			case <-f.closeChan:
+				// envelopes already received are routed before shutting down
+				for {
+					select {
+					case event := <-f.eventsChan:
+						f.queue(event)
+						continue
+					default:
+					}
+					break
+				}
 				f.log.Info("closed firehose")
+				close(f.stopped)
 				return
 
 			case event := <-f.eventsChan:
-				f.Queue.Set(event)
-				atomic.AddInt64(&f.EventCount, 1)
+				f.queue(event)
 				f.log.Tracer("<")
 
 			}
@@ -121,6 +146,31 @@ func Start() *Firehose {
 
 }
 
+// queue an envelope for the router
+func (f *Firehose) queue(e *loggregator_v2.Envelope) {
+	f.Queue.Set(e)
+	atomic.AddInt64(&f.EventCount, 1)
+	atomic.StoreInt64(&f.lastEnvelope, time.Now().UnixNano())
+	telemetry.Count(envelopeCounter(e), 1)
+}
+
+// envelopeCounter names the counter of the envelope's v2 type
+func envelopeCounter(e *loggregator_v2.Envelope) string {
+	switch e.Message.(type) {
+	case *loggregator_v2.Envelope_Log:
+		return "firehose.envelopes.Log"
+	case *loggregator_v2.Envelope_Counter:
+		return "firehose.envelopes.Counter"
+	case *loggregator_v2.Envelope_Gauge:
+		return "firehose.envelopes.Gauge"
+	case *loggregator_v2.Envelope_Timer:
+		return "firehose.envelopes.Timer"
+	case *loggregator_v2.Envelope_Event:
+		return "firehose.envelopes.Event"
+	}
+	return "firehose.envelopes.Unknown"
+}
+
 // StartNozzle creates a context, connects to the RLP Gateway, and places envelopes on the eventsChan.
 func (f *Firehose) startNozzle() {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -142,6 +192,7 @@ func (f *Firehose) startNozzle() {
 
 // RestartNozzle calls the context cancel function, then starts the nozzle again.
 func (f *Firehose) RestartNozzle() {
+	telemetry.Count("firehose.restarts", 1)
 	// Cancel the context, which will stop the current HTTP requests.
 	f.cancel()
 	// Restart the nozzle RLP Gateway connection
@@ -5,6 +5,7 @@ package httpfirehose
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -12,6 +13,8 @@ import (
 
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/api"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/healthcheck"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/telemetry"
 )
 
 // HttpFirehose Object
@@ -38,12 +41,25 @@ func NewHttpFirehose(c *api.Client, conf *config.Config) *HttpFirehose {
 
 // Do will add the token as an authorization header on all HTTP requests from FirehoseHttp
 func (h *HttpFirehose) Do(req *http.Request) (*http.Response, error) {
+	telemetry.Count("firehose.connections", 1)
 	token, err := h.apiClient.Client.GetToken()
+	healthcheck.Track(healthcheck.Token).Result(err)
 	if err != nil {
+		telemetry.Count("firehose.connection.errors", 1)
+		healthcheck.Track(healthcheck.Firehose).Result(err)
 		return nil, err
 	}
 	req.Header.Set("Authorization", token)
 	// Connection should stream for up to 14 minutes.
 	app.Get().Log.Debugln("Issuing new HTTP firehose request")
-	return h.httpClient.Do(req)
+	resp, err := h.httpClient.Do(req)
+	if err == nil && resp.StatusCode >= 400 {
+		healthcheck.Track(healthcheck.Firehose).Result(fmt.Errorf("RLP gateway responded %s", resp.Status))
+	} else {
+		healthcheck.Track(healthcheck.Firehose).Result(err)
+	}
+	if err != nil {
+		telemetry.Count("firehose.connection.errors", 1)
+	}
+	return resp, err
 }
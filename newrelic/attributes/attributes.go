@@ -81,6 +81,13 @@ func (a *Attributes) SetAttribute(name string, v interface{}) *Attribute {
 	return attr
 }
 
+// Remove attribute by name
+func (a *Attributes) Remove(name string) {
+	a.sync.Lock()
+	defer a.sync.Unlock()
+	delete(a.Map, name)
+}
+
 // ForEach iterates attributes with callback function
 func (a *Attributes) ForEach(fn func(attr *Attribute)) {
 	a.sync.Lock()
@@ -4,24 +4,51 @@
 package newrelic
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/insights"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/telemetry"
 )
 
 // Harvester ...
 type Harvester struct {
 	collector *Collector
+	// health is nil unless NEWRELIC_HEALTH_EVENTS is enabled
+	health *health
+	lock   sync.Mutex
+	last   LastHarvest
+}
+
+// LastHarvest describes the most recent harvest
+type LastHarvest struct {
+	Time     time.Time
+	Duration time.Duration
+	Entities int
+	Metrics  int
 }
 
 // NewHarvester ...
 func NewHarvester(c *Collector) *Harvester {
 	i := &Harvester{
 		collector: c,
+		// the harvest age is measured from the start until the first harvest
+		last: LastHarvest{Time: time.Now()},
 	}
 	return i
 }
 
+// Last returns the most recent harvest
+func (h *Harvester) Last() LastHarvest {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.last
+}
+
 // Accumulators return iterable list of registered Accumulators
 func (h *Harvester) Accumulators() []accumulators.Interface {
 	return *h.collector.accumulators
@@ -30,14 +57,36 @@ func (h *Harvester) Accumulators() []accumulators.Interface {
 // Harvest queues processed metrics
 func (h *Harvester) Harvest() {
 	app.Get().Log.Debug("\nHarvest...")
+	start := time.Now()
+	last := LastHarvest{Time: start}
 	for _, accumulator := range h.Accumulators() {
-		for _, entity := range accumulator.Drain() {
-			for _, metric := range entity.DrainMetrics() {
+		name := accumulatorName(accumulator)
+		drained := accumulator.Drain()
+		for _, entity := range drained {
+			metrics := entity.DrainMetrics()
+			for _, metric := range metrics {
 				accumulator.HarvestMetrics(entity, metric)
 			}
+			telemetry.Count("accumulator."+name+".metrics", int64(len(metrics)))
+			last.Metrics += len(metrics)
 		}
+		telemetry.Count("accumulator."+name+".entities", int64(len(drained)))
+		last.Entities += len(drained)
 	}
+	last.Duration = time.Since(start)
+	telemetry.Observe("harvest", last.Duration)
+	h.lock.Lock()
+	h.last = last
+	h.lock.Unlock()
 	app.Get().Log.Debug("Harvest COMPLETE")
+	if h.health != nil {
+		h.health.report()
+	}
 	// Tell the InsertManager to flush all clients.
 	insights.New().FlushAll()
 }
+
+// accumulatorName is the package of the accumulator, such as logmessage
+func accumulatorName(a accumulators.Interface) string {
+	return strings.SplitN(strings.TrimPrefix(fmt.Sprintf("%T", a), "*"), ".", 2)[0]
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/insights"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/telemetry"
+)
+
+// health reports the nozzle's own activity since the previous harvest as
+// PCFNozzleHealth events: one for the nozzle, and one for each account.
+type health struct {
+	cfapps   *cfapps.CFAppManager
+	accounts map[string]insights.Stats
+	rate     cfapps.RateStats
+}
+
+func newHealth(m *cfapps.CFAppManager) *health {
+	return &health{cfapps: m, accounts: map[string]insights.Stats{}}
+}
+
+// report the events to the default account
+func (h *health) report() {
+	cfg := app.Get().Config
+	client := insights.New().Default()
+	for _, event := range h.events() {
+		event["eventType"] = cfg.GetString(config.NewRelicEventTypeHealth)
+		event["agent.subscription"] = cfg.GetString("FIREHOSE_ID")
+		client.EnqueueEvent(event)
+	}
+}
+
+func (h *health) events() []map[string]interface{} {
+	nozzle := nrpcf.AgentAttributes().Marshal()
+	nozzle["health.component"] = "nozzle"
+	s := telemetry.Get().Harvest()
+	for name, n := range s.Counters {
+		nozzle[name] = n
+	}
+	for name, t := range s.Timers {
+		nozzle[name+".count"] = t.Count
+		nozzle[name+".avg.ms"] = milliseconds(t.Average())
+		nozzle[name+".max.ms"] = milliseconds(t.Max)
+	}
+
+	rate := h.cfapps.RateStats()
+	nozzle["cfapi.limiter.queued"] = rate.Queued
+	nozzle["cfapi.limiter.active"] = rate.Active
+	nozzle["cfapi.limiter.timeouts"] = rate.Timeouts - h.rate.Timeouts
+	if granted := rate.Granted - h.rate.Granted; granted > 0 {
+		nozzle["cfapi.limiter.wait.avg.ms"] = milliseconds((rate.WaitTime - h.rate.WaitTime) / time.Duration(granted))
+	}
+	h.rate = rate
+
+	return append([]map[string]interface{}{nozzle}, h.accountEvents(insights.New().Stats())...)
+}
+
+// accountEvents of the changes in each account's stats since the previous call
+func (h *health) accountEvents(accounts map[string]insights.Stats) []map[string]interface{} {
+	events := []map[string]interface{}{}
+	for id, stats := range accounts {
+		last := h.accounts[id]
+		account := nrpcf.AgentAttributes().Marshal()
+		account["health.component"] = "account"
+		account["account.id"] = id
+		account["events.queued"] = stats.Queued - last.Queued
+		account["events.posted"] = stats.Posted - last.Posted
+		account["events.failed"] = stats.Failed - last.Failed
+		account["events.dropped"] = stats.Dropped - last.Dropped
+		account["events.spooled"] = stats.Spooled - last.Spooled
+		account["events.replayed"] = stats.Replayed - last.Replayed
+		account["events.spool.dropped"] = stats.SpoolDropped - last.SpoolDropped
+		account["events.diverted"] = stats.Diverted - last.Diverted
+		account["events.broken"] = stats.Broken - last.Broken
+		account["breaker.open"] = stats.BreakerOpen
+		h.accounts[id] = stats
+		events = append(events, account)
+	}
+	return events
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
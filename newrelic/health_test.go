@@ -0,0 +1,76 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/insights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("health", func() {
+	var h *health
+
+	// events by account ID
+	byAccount := func(events []map[string]interface{}) map[string]map[string]interface{} {
+		m := map[string]map[string]interface{}{}
+		for _, e := range events {
+			Expect(e).To(HaveKeyWithValue("health.component", "account"))
+			m[e["account.id"].(string)] = e
+		}
+		return m
+	}
+
+	BeforeEach(func() {
+		h = newHealth(nil)
+	})
+
+	It("reports the stats of each account since the previous harvest", func() {
+		first := byAccount(h.accountEvents(map[string]insights.Stats{
+			"1": {Queued: 10, Posted: 8, Failed: 2},
+			"2": {Queued: 5, Posted: 5, Diverted: 1, BreakerOpen: true},
+		}))
+		Expect(first).To(HaveLen(2))
+		Expect(first["1"]).To(SatisfyAll(
+			HaveKeyWithValue("events.queued", int64(10)),
+			HaveKeyWithValue("events.posted", int64(8)),
+			HaveKeyWithValue("events.failed", int64(2)),
+			HaveKeyWithValue("breaker.open", false),
+		))
+		Expect(first["2"]).To(SatisfyAll(
+			HaveKeyWithValue("events.diverted", int64(1)),
+			HaveKeyWithValue("breaker.open", true),
+		))
+
+		second := byAccount(h.accountEvents(map[string]insights.Stats{
+			"1": {Queued: 25, Posted: 20, Failed: 2, Spooled: 3},
+			"2": {Queued: 5, Posted: 5, Diverted: 1},
+		}))
+		Expect(second["1"]).To(SatisfyAll(
+			HaveKeyWithValue("events.queued", int64(15)),
+			HaveKeyWithValue("events.posted", int64(12)),
+			HaveKeyWithValue("events.failed", int64(0)),
+			HaveKeyWithValue("events.spooled", int64(3)),
+		))
+		Expect(second["2"]).To(SatisfyAll(
+			HaveKeyWithValue("events.queued", int64(0)),
+			HaveKeyWithValue("events.diverted", int64(0)),
+			HaveKeyWithValue("breaker.open", false),
+		))
+	})
+
+	It("reports accounts seen for the first time from zero", func() {
+		h.accountEvents(map[string]insights.Stats{"1": {Queued: 10}})
+		events := byAccount(h.accountEvents(map[string]insights.Stats{
+			"1": {Queued: 10},
+			"3": {Queued: 4, Broken: 2},
+		}))
+		Expect(events["1"]).To(HaveKeyWithValue("events.queued", int64(0)))
+		Expect(events["3"]).To(SatisfyAll(
+			HaveKeyWithValue("events.queued", int64(4)),
+			HaveKeyWithValue("events.broken", int64(2)),
+		))
+	})
+})
@@ -4,16 +4,44 @@
 package healthcheck
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 )
 
+// Component state reported by /health/ready
+type Component struct {
+	Healthy bool                   `json:"healthy"`
+	Reason  string                 `json:"reason,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Check returns the current state of a component
+type Check func() Component
+
+var (
+	checks  = map[string]Check{}
+	lock    sync.RWMutex
+	started = time.Now()
+)
+
+// Register the Check of a named component for /health/ready
+func Register(name string, check Check) {
+	lock.Lock()
+	checks[name] = check
+	lock.Unlock()
+}
+
 // Start creates a HTTP server that listens and responds to /health requests
 func Start() {
 	go func() {
 		http.HandleFunc("/health", healthCheckHandler)
+		http.HandleFunc("/health/live", liveHandler)
+		http.HandleFunc("/health/ready", readyHandler)
 		app.Get().Log.Fatal(http.ListenAndServe(":"+app.Get().Config.GetString("HEALTH_PORT"), nil))
 	}()
 }
@@ -22,3 +50,50 @@ func Start() {
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "I'm alive and well!")
 }
+
+// liveHandler answers while the process is serving requests
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, map[string]interface{}{
+		"status":   "live",
+		"uptime_s": int64(time.Since(started).Seconds()),
+	})
+}
+
+// readyHandler fails when any component is unhealthy, so the CF HTTP health
+// check restarts a wedged instance
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	components, ready := Status()
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	respond(w, code, map[string]interface{}{
+		"status":     status,
+		"components": components,
+	})
+}
+
+// Status of every registered component, and whether all are healthy
+func Status() (map[string]Component, bool) {
+	lock.RLock()
+	list := make(map[string]Check, len(checks))
+	for name, check := range checks {
+		list[name] = check
+	}
+	lock.RUnlock()
+
+	components := make(map[string]Component, len(list))
+	ready := true
+	for name, check := range list {
+		c := check()
+		components[name] = c
+		ready = ready && c.Healthy
+	}
+	return components, ready
+}
+
+func respond(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package healthcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("readiness", func() {
+	healthy := func() Component { return Component{Healthy: true} }
+	unhealthy := func() Component { return Component{Healthy: false, Reason: "wedged"} }
+
+	// ready calls /health/ready, returning the status code and body
+	ready := func() (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		readyHandler(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+		body := map[string]interface{}{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		return w.Code, body
+	}
+
+	BeforeEach(func() {
+		lock.Lock()
+		checks = map[string]Check{}
+		lock.Unlock()
+	})
+
+	It("is ready when every component is healthy", func() {
+		Register("a", healthy)
+		Register("b", healthy)
+		components, ok := Status()
+		Expect(ok).To(BeTrue())
+		Expect(components).To(HaveLen(2))
+
+		code, body := ready()
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(HaveKeyWithValue("status", "ready"))
+	})
+
+	It("is not ready when a component is unhealthy", func() {
+		Register("a", healthy)
+		Register("b", unhealthy)
+		components, ok := Status()
+		Expect(ok).To(BeFalse())
+		Expect(components["b"].Reason).To(Equal("wedged"))
+
+		code, body := ready()
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(HaveKeyWithValue("status", "not ready"))
+		Expect(body["components"]).To(HaveKeyWithValue("b", HaveKeyWithValue("reason", "wedged")))
+	})
+
+	It("is ready without components", func() {
+		code, _ := ready()
+		Expect(code).To(Equal(http.StatusOK))
+	})
+
+	It("replaces components registered again", func() {
+		Register("a", unhealthy)
+		Register("a", healthy)
+		_, ok := Status()
+		Expect(ok).To(BeTrue())
+	})
+})
+
+var _ = Describe("Tracker", func() {
+	It("reports no failures before any result", func() {
+		c := (&Tracker{}).Component("ok")
+		Expect(c.Healthy).To(BeTrue())
+		Expect(c.Details).To(HaveKeyWithValue("ok", true))
+		Expect(c.Details).To(HaveKeyWithValue("consecutive_failures", 0))
+		Expect(c.Details).NotTo(HaveKey("last_success"))
+		Expect(c.Details).NotTo(HaveKey("failing_s"))
+	})
+
+	It("reports consecutive failures as details only", func() {
+		t := &Tracker{}
+		t.Result(nil)
+		t.Result(errors.New("refused"))
+		t.Result(errors.New("timeout"))
+		c := t.Component("ok")
+		Expect(c.Healthy).To(BeTrue())
+		Expect(c.Reason).To(BeEmpty())
+		Expect(c.Details).To(HaveKeyWithValue("ok", false))
+		Expect(c.Details).To(HaveKeyWithValue("consecutive_failures", 2))
+		Expect(c.Details).To(HaveKeyWithValue("last_error", "timeout"))
+		Expect(c.Details).To(HaveKey("last_success"))
+		Expect(c.Details).To(HaveKey("last_failure"))
+		Expect(c.Details).To(HaveKey("failing_s"))
+	})
+
+	It("resets the failures on success", func() {
+		t := &Tracker{}
+		t.Result(errors.New("refused"))
+		t.Result(nil)
+		c := t.Component("ok")
+		Expect(c.Details).To(HaveKeyWithValue("ok", true))
+		Expect(c.Details).To(HaveKeyWithValue("consecutive_failures", 0))
+		Expect(c.Details).To(HaveKeyWithValue("last_error", "refused"))
+		Expect(c.Details).NotTo(HaveKey("failing_s"))
+	})
+
+	It("returns the same Tracker for a name", func() {
+		Expect(Track("test")).To(BeIdenticalTo(Track("test")))
+		Expect(Track("test")).NotTo(BeIdenticalTo(Track("other")))
+	})
+})
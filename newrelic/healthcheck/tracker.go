@@ -0,0 +1,80 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package healthcheck
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracked operations
+const (
+	Firehose = "firehose"
+	Token    = "token"
+	CFAPI    = "cfapi"
+	Sink     = "sink"
+)
+
+// Tracker of the results of a recurring operation
+type Tracker struct {
+	lock         sync.Mutex
+	lastSuccess  time.Time
+	lastFailure  time.Time
+	failingSince time.Time
+	failures     int
+	lastError    string
+}
+
+var trackers sync.Map
+
+// Track returns the Tracker of the named operation
+func Track(name string) *Tracker {
+	t, found := trackers.Load(name)
+	if !found {
+		t, _ = trackers.LoadOrStore(name, &Tracker{})
+	}
+	return t.(*Tracker)
+}
+
+// Result of the operation, a nil err is a success
+func (t *Tracker) Result(err error) {
+	now := time.Now()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err == nil {
+		t.lastSuccess = now
+		t.failingSince = time.Time{}
+		t.failures = 0
+		return
+	}
+	if t.failures == 0 {
+		t.failingSince = now
+	}
+	t.lastFailure = now
+	t.failures++
+	t.lastError = err.Error()
+}
+
+// Component state of the operation, reported as details only: remote
+// failures do not make the nozzle unready, a restart would not fix them.
+// The detail named ok is whether the last result was a success.
+func (t *Tracker) Component(ok string) Component {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	c := Component{Healthy: true, Details: map[string]interface{}{
+		ok:                     t.failures == 0,
+		"consecutive_failures": t.failures,
+	}}
+	if !t.lastSuccess.IsZero() {
+		c.Details["last_success"] = t.lastSuccess.UTC().Format(time.RFC3339)
+	}
+	if !t.lastFailure.IsZero() {
+		c.Details["last_failure"] = t.lastFailure.UTC().Format(time.RFC3339)
+		c.Details["last_error"] = t.lastError
+	}
+	if t.failures > 0 {
+		c.Details["failing_s"] = time.Since(t.failingSince).Seconds()
+	}
+	return c
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package insights
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+)
+
+// limits of the Event API, from the NEWRELIC_MAX_* settings
+type limits struct {
+	payload      int
+	uncompressed int
+	attributes   int
+	nameLength   int
+	valueLength  int
+}
+
+func newLimits() limits {
+	c := app.Get().Config
+	return limits{
+		payload:      c.GetInt("NEWRELIC_MAX_PAYLOAD_BYTES"),
+		uncompressed: c.GetInt("NEWRELIC_MAX_UNCOMPRESSED_BYTES"),
+		attributes:   c.GetInt("NEWRELIC_MAX_ATTRIBUTES"),
+		nameLength:   c.GetInt("NEWRELIC_MAX_ATTRIBUTE_NAME_LENGTH"),
+		valueLength:  c.GetInt("NEWRELIC_MAX_ATTRIBUTE_VALUE_LENGTH"),
+	}
+}
+
+// required attributes are never dropped to fit the attribute count
+var required = map[string]bool{"eventType": true, "timestamp": true}
+
+// sourced is an event with the accumulator that produced it
+type sourced struct {
+	accumulator string
+	event       interface{}
+}
+
+// nozzle is the source of events without an accumulator, such as health events
+const nozzle = "nozzle"
+
+// limited counts the attributes changed to fit the limits, by accumulator
+type limited struct {
+	truncated int
+	dropped   int
+}
+
+// enforce the attribute limits on an event, truncating long string values
+// and dropping attributes with long names, or over the attribute count.
+// Required attributes are never changed.
+func (l limits) enforce(event map[string]interface{}) (truncated int, dropped int) {
+	for name, v := range event {
+		if required[name] {
+			continue
+		}
+		if l.nameLength > 0 && len(name) > l.nameLength {
+			delete(event, name)
+			dropped++
+			continue
+		}
+		if s, ok := v.(string); ok && l.valueLength > 0 && len(s) > l.valueLength {
+			event[name] = truncate(s, l.valueLength)
+			truncated++
+		}
+	}
+	if l.attributes <= 0 || len(event) <= l.attributes {
+		return
+	}
+	// the same attributes are kept for every event of a type
+	names := make([]string, 0, len(event))
+	for name := range event {
+		if !required[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	keep := l.attributes - (len(event) - len(names))
+	if keep < 0 {
+		keep = 0
+	}
+	for _, name := range names[keep:] {
+		delete(event, name)
+		dropped++
+	}
+	return
+}
+
+// truncate s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// encode an event within the attribute limits
+func (c *Client) encode(event interface{}) ([]byte, error) {
+	accumulator := nozzle
+	if s, ok := event.(sourced); ok {
+		accumulator, event = s.accumulator, s.event
+	}
+	var m map[string]interface{}
+	switch e := event.(type) {
+	case map[string]interface{}:
+		m = e
+	case *map[string]interface{}:
+		m = *e
+	}
+	if m != nil {
+		if truncated, dropped := c.limits.enforce(m); truncated+dropped > 0 {
+			l, found := c.limited[accumulator]
+			if !found {
+				l = &limited{}
+				c.limited[accumulator] = l
+			}
+			l.truncated += truncated
+			l.dropped += dropped
+		}
+		return json.Marshal(m)
+	}
+	return json.Marshal(event)
+}
+
+// pack encoded events into payloads within the uncompressed and
+// compressed size limits, events that can not fit alone are dropped.
+func (c *Client) pack(events [][]byte) (payloads [][]byte, counts []int) {
+	var (
+		buf   bytes.Buffer
+		count int
+	)
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		buf.WriteByte(']')
+		p, n := c.fit(append([]byte{}, buf.Bytes()...), count)
+		payloads, counts = append(payloads, p...), append(counts, n...)
+		buf.Reset()
+		count = 0
+	}
+	for _, e := range events {
+		if c.limits.uncompressed > 0 && len(e)+2 > c.limits.uncompressed {
+			c.oversized++
+			atomic.AddInt64(&c.stats.Dropped, 1)
+			continue
+		}
+		if count > 0 && c.limits.uncompressed > 0 && buf.Len()+len(e)+2 > c.limits.uncompressed {
+			flush()
+		}
+		if count == 0 {
+			buf.WriteByte('[')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.Write(e)
+		count++
+	}
+	flush()
+	return
+}
+
+// fit a payload within the compressed size limit, splitting it in halves
+func (c *Client) fit(payload []byte, count int) ([][]byte, []int) {
+	// payloads under the limit before compression always fit
+	if c.limits.payload <= 0 || len(payload) <= c.limits.payload || compressedSize(payload) <= c.limits.payload {
+		return [][]byte{payload}, []int{count}
+	}
+	if count == 1 {
+		c.oversized++
+		atomic.AddInt64(&c.stats.Dropped, 1)
+		return nil, nil
+	}
+	var events []json.RawMessage
+	if err := json.Unmarshal(payload, &events); err != nil {
+		return [][]byte{payload}, []int{count}
+	}
+	half := len(events) / 2
+	left, _ := json.Marshal(events[:half])
+	right, _ := json.Marshal(events[half:])
+	p1, n1 := c.fit(left, half)
+	p2, n2 := c.fit(right, len(events)-half)
+	return append(p1, p2...), append(n1, n2...)
+}
+
+func compressedSize(payload []byte) int {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(payload)
+	w.Close()
+	return buf.Len()
+}
+
+// logLimited reports the attributes changed to fit the limits since the last call
+func (c *Client) logLimited() {
+	for accumulator, l := range c.limited {
+		app.Get().Log.Warnf(
+			"%s events of account %s exceeded attribute limits: truncated %d values and dropped %d attributes",
+			accumulator, c.AccountID, l.truncated, l.dropped,
+		)
+		delete(c.limited, accumulator)
+	}
+	if c.oversized > 0 {
+		app.Get().Log.Warnf("dropped %d events of account %s too large to post", c.oversized, c.AccountID)
+		c.oversized = 0
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package insights
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func testClient(l limits) *Client {
+	return &Client{stats: &Stats{}, limits: l, limited: map[string]*limited{}}
+}
+
+// encoded events of about size bytes each
+func encoded(n int, size int) [][]byte {
+	events := make([][]byte, n)
+	for i := range events {
+		events[i] = []byte(fmt.Sprintf(`{"eventType":"Test","i":%d,"v":"%s"}`, i, strings.Repeat("x", size)))
+	}
+	return events
+}
+
+// unpack the events of payloads, checking each is a valid JSON array
+func unpack(payloads [][]byte, counts []int) []json.RawMessage {
+	Expect(payloads).To(HaveLen(len(counts)))
+	all := []json.RawMessage{}
+	for i, p := range payloads {
+		var events []json.RawMessage
+		Expect(json.Unmarshal(p, &events)).To(Succeed())
+		Expect(events).To(HaveLen(counts[i]))
+		all = append(all, events...)
+	}
+	return all
+}
+
+var _ = Describe("limits", func() {
+	for _, c := range []struct {
+		name      string
+		limits    limits
+		event     map[string]interface{}
+		expected  map[string]interface{}
+		truncated int
+		dropped   int
+	}{
+		{
+			"keeps events within the limits",
+			limits{attributes: 3, nameLength: 10, valueLength: 5},
+			map[string]interface{}{"eventType": "Test", "a": "12345", "b": 123456},
+			map[string]interface{}{"eventType": "Test", "a": "12345", "b": 123456},
+			0, 0,
+		},
+		{
+			"truncates long string values",
+			limits{valueLength: 5},
+			map[string]interface{}{"eventType": "Test", "a": "123456789"},
+			map[string]interface{}{"eventType": "Test", "a": "12345"},
+			1, 0,
+		},
+		{
+			"truncates on a character boundary",
+			limits{valueLength: 5},
+			map[string]interface{}{"a": "1234€"},
+			map[string]interface{}{"a": "1234"},
+			1, 0,
+		},
+		{
+			"drops attributes with long names",
+			limits{nameLength: 5},
+			map[string]interface{}{"eventType": "Test", "long.name": 1, "short": 2},
+			map[string]interface{}{"eventType": "Test", "short": 2},
+			0, 1,
+		},
+		{
+			"keeps required attributes whatever the length limits",
+			limits{nameLength: 1, valueLength: 2},
+			map[string]interface{}{"eventType": "Test", "timestamp": 1, "a": "abc"},
+			map[string]interface{}{"eventType": "Test", "timestamp": 1, "a": "ab"},
+			1, 0,
+		},
+		{
+			"drops attributes over the count by name, keeping required ones",
+			limits{attributes: 3},
+			map[string]interface{}{"eventType": "Test", "timestamp": 1, "c": 3, "a": 1, "b": 2},
+			map[string]interface{}{"eventType": "Test", "timestamp": 1, "a": 1},
+			0, 2,
+		},
+		{
+			"keeps required attributes over the count",
+			limits{attributes: 1},
+			map[string]interface{}{"eventType": "Test", "timestamp": 1, "a": 1},
+			map[string]interface{}{"eventType": "Test", "timestamp": 1},
+			0, 1,
+		},
+		{
+			"ignores unset limits",
+			limits{},
+			map[string]interface{}{"eventType": "Test", "a.long.attribute.name": strings.Repeat("x", 5000)},
+			map[string]interface{}{"eventType": "Test", "a.long.attribute.name": strings.Repeat("x", 5000)},
+			0, 0,
+		},
+	} {
+		c := c
+		It(c.name, func() {
+			// enforce changes the event, the table is kept for repeated runs
+			event := make(map[string]interface{}, len(c.event))
+			for k, v := range c.event {
+				event[k] = v
+			}
+			truncated, dropped := c.limits.enforce(event)
+			Expect(event).To(Equal(c.expected))
+			Expect(truncated).To(Equal(c.truncated))
+			Expect(dropped).To(Equal(c.dropped))
+		})
+	}
+
+	It("counts limited attributes by accumulator", func() {
+		c := testClient(limits{valueLength: 2})
+		_, err := c.encode(sourced{"value", map[string]interface{}{"eventType": "Test", "a": "abc", "b": "abc"}})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = c.encode(map[string]interface{}{"eventType": "Test", "a": "abc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.limited).To(Equal(map[string]*limited{
+			"value":  {truncated: 2},
+			"nozzle": {truncated: 1},
+		}))
+	})
+})
+
+var _ = Describe("pack", func() {
+	It("packs events in one payload within the limits", func() {
+		c := testClient(limits{uncompressed: 1 << 20})
+		payloads, counts := c.pack(encoded(10, 10))
+		Expect(payloads).To(HaveLen(1))
+		Expect(unpack(payloads, counts)).To(HaveLen(10))
+	})
+
+	It("splits payloads at the uncompressed limit", func() {
+		c := testClient(limits{uncompressed: 200})
+		events := encoded(10, 20)
+		payloads, counts := c.pack(events)
+		Expect(len(payloads)).To(BeNumerically(">", 1))
+		for _, p := range payloads {
+			Expect(len(p)).To(BeNumerically("<=", 200))
+		}
+		unpacked := unpack(payloads, counts)
+		Expect(unpacked).To(HaveLen(10))
+		for i, e := range unpacked {
+			Expect(string(e)).To(Equal(string(events[i])))
+		}
+	})
+
+	It("drops events too large alone", func() {
+		c := testClient(limits{uncompressed: 100})
+		events := append(encoded(2, 10), encoded(1, 200)...)
+		payloads, counts := c.pack(events)
+		Expect(unpack(payloads, counts)).To(HaveLen(2))
+		Expect(c.stats.Dropped).To(Equal(int64(1)))
+		Expect(c.oversized).To(Equal(1))
+	})
+
+	It("returns nothing without events", func() {
+		payloads, counts := testClient(limits{}).pack(nil)
+		Expect(payloads).To(BeEmpty())
+		Expect(counts).To(BeEmpty())
+	})
+})
+
+var _ = Describe("fit", func() {
+	// random values compress poorly
+	random := func(n int, size int) []byte {
+		r := rand.New(rand.NewSource(1))
+		events := make([]string, n)
+		for i := range events {
+			b := make([]byte, size)
+			for j := range b {
+				b[j] = byte('a' + r.Intn(26))
+			}
+			events[i] = fmt.Sprintf(`{"eventType":"Test","v":"%s"}`, b)
+		}
+		return []byte("[" + strings.Join(events, ",") + "]")
+	}
+
+	It("keeps payloads that compress within the limit", func() {
+		c := testClient(limits{payload: 1000})
+		payload := []byte("[" + strings.Repeat(`{"eventType":"Test"},`, 200) + `{"eventType":"Test"}]`)
+		payloads, counts := c.fit(payload, 201)
+		Expect(payloads).To(HaveLen(1))
+		Expect(counts).To(Equal([]int{201}))
+	})
+
+	It("splits payloads over the compressed limit in halves", func() {
+		c := testClient(limits{payload: 1000})
+		payloads, counts := c.fit(random(8, 400), 8)
+		Expect(len(payloads)).To(BeNumerically(">", 1))
+		for _, p := range payloads {
+			Expect(compressedSize(p)).To(BeNumerically("<=", 1000))
+		}
+		Expect(unpack(payloads, counts)).To(HaveLen(8))
+	})
+
+	It("drops single events over the compressed limit", func() {
+		c := testClient(limits{payload: 100})
+		payloads, counts := c.fit(random(1, 400), 1)
+		Expect(payloads).To(BeEmpty())
+		Expect(counts).To(BeEmpty())
+		Expect(c.stats.Dropped).To(Equal(int64(1)))
+	})
+})
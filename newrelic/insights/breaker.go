@@ -0,0 +1,199 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package insights
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/healthcheck"
+)
+
+// Breaker actions for the events of an account while its breaker is open
+const (
+	BreakerFallback = "fallback"
+	BreakerDrop     = "drop"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// AgentAttributes are added to the events the nozzle reports about itself,
+// set at startup to avoid importing nrpcf.
+var AgentAttributes = func() map[string]interface{} { return map[string]interface{}{} }
+
+// breaker of an account, opened after NEWRELIC_BREAKER_FAILURES consecutive
+// 403 or 5xx responses. Once NEWRELIC_BREAKER_RESET has passed it half-opens
+// and admits a single probe event, the result of its post closes or opens it
+// again. A probe that is never posted is replaced after another reset.
+type breaker struct {
+	threshold int
+	reset     time.Duration
+	action    string
+	lock      sync.Mutex
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	probedAt  time.Time
+}
+
+// newBreaker from the NEWRELIC_BREAKER_* settings, nil when disabled
+func newBreaker() *breaker {
+	c := app.Get().Config
+	if c.GetInt("NEWRELIC_BREAKER_FAILURES") <= 0 {
+		return nil
+	}
+	b := &breaker{
+		threshold: c.GetInt("NEWRELIC_BREAKER_FAILURES"),
+		reset:     c.GetDuration("NEWRELIC_BREAKER_RESET"),
+		action:    BreakerFallback,
+	}
+	if c.GetString("NEWRELIC_BREAKER_ACTION") == BreakerDrop {
+		b.action = BreakerDrop
+	}
+	return b
+}
+
+// allow reports whether an event may be queued for the account
+func (b *breaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.reset {
+			return false
+		}
+		b.state = breakerHalfOpen
+	case breakerHalfOpen:
+		if time.Since(b.probedAt) < b.reset {
+			return false
+		}
+	default:
+		return true
+	}
+	b.probedAt = time.Now()
+	return true
+}
+
+// success closes the breaker, returning whether it was not closed
+func (b *breaker) success() (recovered bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	recovered = b.state != breakerClosed
+	b.state = breakerClosed
+	b.failures = 0
+	return
+}
+
+// failure counts a 403 or 5xx response, returning whether it opened the breaker
+func (b *breaker) failure() (opened bool, failures int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		return true, b.failures
+	}
+	return false, b.failures
+}
+
+func (b *breaker) isOpen() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state == breakerOpen
+}
+
+// breaks reports whether a post error counts toward opening the breaker
+func breaks(err error) (int, bool) {
+	s := status(err)
+	return s, s == 403 || s >= 500
+}
+
+// record the result of a post to the breaker of the account, posts to the
+// default account are the sink state of /health/ready
+func (c *Client) record(err error) {
+	if c.isDefault {
+		healthcheck.Track(healthcheck.Sink).Result(err)
+	}
+	if c.breaker == nil {
+		return
+	}
+	if err == nil {
+		if c.breaker.success() {
+			app.Get().Log.Infof("insert circuit of account %s closed, posting resumed", c.AccountID)
+		}
+		return
+	}
+	status, ok := breaks(err)
+	if !ok {
+		return
+	}
+	if opened, failures := c.breaker.failure(); opened {
+		app.Get().Log.Warnf(
+			"insert circuit of account %s opened after %d failed posts, retrying in %s (events %s): %s",
+			c.AccountID, failures, c.breaker.reset, c.breaker.action, err.Error(),
+		)
+		c.reportOpen(status, failures, err)
+		// events queued before it opened are not posted either
+		c.divert(c.takeAll())
+	}
+}
+
+// divert events while the breaker is open, to the default account or
+// dropped per NEWRELIC_BREAKER_ACTION
+func (c *Client) divert(events []interface{}) (err error) {
+	if len(events) == 0 {
+		return nil
+	}
+	if c.breaker.action == BreakerDrop {
+		atomic.AddInt64(&c.stats.Broken, int64(len(events)))
+		return nil
+	}
+	atomic.AddInt64(&c.stats.Diverted, int64(len(events)))
+	d := c.manager.Default()
+	for _, event := range events {
+		if e := d.EnqueueEvent(event); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// divertPayload of encoded events, split back into single events
+func (c *Client) divertPayload(payload json.RawMessage, events int) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(payload, &list); err != nil {
+		atomic.AddInt64(&c.stats.Broken, int64(events))
+		return
+	}
+	diverted := make([]interface{}, len(list))
+	for i, e := range list {
+		diverted[i] = e
+	}
+	c.divert(diverted)
+}
+
+// reportOpen sends an account error event to the default account
+func (c *Client) reportOpen(status int, failures int, err error) {
+	event := AgentAttributes()
+	event["eventType"] = app.Get().Config.GetString(config.NewRelicEventTypeAccountError)
+	event["agent.subscription"] = app.Get().Config.GetString("FIREHOSE_ID")
+	event["account.id"] = c.AccountID
+	event["account.region"] = c.Region
+	event["http.status"] = status
+	event["error"] = err.Error()
+	event["breaker.failures"] = failures
+	event["breaker.action"] = c.breaker.action
+	c.manager.Default().EnqueueEvent(event)
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package insights
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("breaker", func() {
+	var b *breaker
+
+	BeforeEach(func() {
+		b = &breaker{threshold: 2, reset: time.Minute, action: BreakerDrop}
+	})
+
+	// expire the reset delay of the breaker and its probe
+	expire := func() {
+		b.openedAt = b.openedAt.Add(-b.reset)
+		b.probedAt = b.probedAt.Add(-b.reset)
+	}
+
+	It("opens after threshold consecutive failures", func() {
+		opened, failures := b.failure()
+		Expect(opened).To(BeFalse())
+		Expect(failures).To(Equal(1))
+		Expect(b.allow()).To(BeTrue())
+
+		opened, failures = b.failure()
+		Expect(opened).To(BeTrue())
+		Expect(failures).To(Equal(2))
+		Expect(b.isOpen()).To(BeTrue())
+		Expect(b.allow()).To(BeFalse())
+	})
+
+	It("counts consecutive failures only", func() {
+		b.failure()
+		Expect(b.success()).To(BeFalse())
+		opened, _ := b.failure()
+		Expect(opened).To(BeFalse())
+	})
+
+	It("admits a single probe once the reset delay has passed", func() {
+		b.failure()
+		b.failure()
+		expire()
+		Expect(b.allow()).To(BeTrue())
+		Expect(b.isOpen()).To(BeFalse())
+		Expect(b.allow()).To(BeFalse())
+		Expect(b.allow()).To(BeFalse())
+	})
+
+	It("closes when the probe succeeds", func() {
+		b.failure()
+		b.failure()
+		expire()
+		Expect(b.allow()).To(BeTrue())
+		Expect(b.success()).To(BeTrue())
+		Expect(b.allow()).To(BeTrue())
+		Expect(b.allow()).To(BeTrue())
+	})
+
+	It("opens again when the probe fails", func() {
+		b.failure()
+		b.failure()
+		expire()
+		Expect(b.allow()).To(BeTrue())
+		opened, _ := b.failure()
+		Expect(opened).To(BeTrue())
+		Expect(b.allow()).To(BeFalse())
+	})
+
+	It("replaces a probe that was not posted after the reset delay", func() {
+		b.failure()
+		b.failure()
+		expire()
+		Expect(b.allow()).To(BeTrue())
+		Expect(b.allow()).To(BeFalse())
+		expire()
+		Expect(b.allow()).To(BeTrue())
+		Expect(b.allow()).To(BeFalse())
+	})
+})
+
+var _ = Describe("record", func() {
+	var c *Client
+
+	// queued events of a Client
+	queued := func(c *Client) int {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return len(c.events)
+	}
+
+	BeforeEach(func() {
+		c = &Client{
+			AccountID: "1",
+			manager:   New(),
+			stats:     &Stats{},
+			breaker:   &breaker{threshold: 1, reset: time.Minute, action: BreakerDrop},
+			events:    []interface{}{"a", "b", "c"},
+			batchSize: 10,
+			queueSize: 10,
+		}
+	})
+
+	It("ignores failures that do not break", func() {
+		c.record(newPostError(response(400)))
+		Expect(c.breaker.isOpen()).To(BeFalse())
+		Expect(queued(c)).To(Equal(3))
+	})
+
+	It("drops the queued events when the breaker opens", func() {
+		c.record(newPostError(response(503)))
+		Expect(c.breaker.isOpen()).To(BeTrue())
+		Expect(queued(c)).To(Equal(0))
+		Expect(c.stats.Broken).To(Equal(int64(3)))
+
+		Expect(c.EnqueueEvent("d")).To(Succeed())
+		Expect(queued(c)).To(Equal(0))
+		Expect(c.stats.Broken).To(Equal(int64(4)))
+	})
+
+	It("diverts the queued events to the default account when the breaker opens", func() {
+		c.breaker.action = BreakerFallback
+		d := New().Default()
+		before := queued(d)
+		c.record(newPostError(response(403)))
+		Expect(queued(c)).To(Equal(0))
+		Expect(c.stats.Diverted).To(Equal(int64(3)))
+		// with the account error event
+		Expect(queued(d)).To(Equal(before + 4))
+	})
+
+	It("diverts payloads posted after the breaker opened", func() {
+		c.breaker.action = BreakerFallback
+		d := New().Default()
+		c.record(newPostError(response(503)))
+		before := queued(d)
+		c.send([]byte(`[{"eventType":"Test"},{"eventType":"Test"}]`), 2)
+		Expect(c.stats.Diverted).To(Equal(int64(5)))
+		Expect(queued(d)).To(Equal(before + 2))
+	})
+})
@@ -0,0 +1,424 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package insights
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/newrelic/go-insights/client"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/spool"
+)
+
+var errQueueFull = errors.New("insert queue full, event dropped")
+
+// Stats of the events of an account, counted since the nozzle started
+type Stats struct {
+	Queued  int64
+	Posted  int64
+	Failed  int64
+	Dropped int64
+	// Spooled events failed to post and were written to the spool, Replayed
+	// events were posted from it, SpoolDropped events were dropped when it was full.
+	Spooled      int64
+	Replayed     int64
+	SpoolDropped int64
+	// Diverted events were sent to the default account while the account's
+	// breaker was open, Broken events were dropped while it was open.
+	Diverted    int64
+	Broken      int64
+	BreakerOpen bool
+}
+
+func (s *Stats) snapshot() Stats {
+	return Stats{
+		Queued:       atomic.LoadInt64(&s.Queued),
+		Posted:       atomic.LoadInt64(&s.Posted),
+		Failed:       atomic.LoadInt64(&s.Failed),
+		Dropped:      atomic.LoadInt64(&s.Dropped),
+		Spooled:      atomic.LoadInt64(&s.Spooled),
+		Replayed:     atomic.LoadInt64(&s.Replayed),
+		SpoolDropped: atomic.LoadInt64(&s.SpoolDropped),
+		Diverted:     atomic.LoadInt64(&s.Diverted),
+		Broken:       atomic.LoadInt64(&s.Broken),
+	}
+}
+
+// Client queues the events of one insert key and posts them in batches,
+// when a batch is full or the InsertManager flushes.
+type Client struct {
+	InsertKey string
+	AccountID string
+	Region    string
+	insert    *client.InsertClient
+	manager   *InsertManager
+	stats     *Stats
+	breaker   *breaker
+	isDefault bool
+	batchSize int
+	queueSize int
+	lastUsed  int64
+	lock      sync.Mutex
+	events    []interface{}
+	closed    bool
+	flush     chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	// spool, retry and limit state are only used by the run goroutine
+	spool      *spool.Spool
+	retryAt    time.Time
+	retryDelay time.Duration
+	limits     limits
+	limited    map[string]*limited
+	oversized  int
+}
+
+func newClient(im *InsertManager, insertKey string, accountID string, region string, stats *Stats, b *breaker) *Client {
+	insertClient := client.NewInsertClient(insertKey, accountID)
+	insertClient.Logger.Out = os.Stdout
+	insertClient.SetCompression(client.Gzip) //always use compression to Insights
+	if region == "EU" {
+		//UseCustomURL only sets the host (domain) of the URL
+		insertClient.UseCustomURL(app.Get().Config.GetString("NEWRELIC_EU_BASE_URL"))
+	}
+	c := &Client{
+		InsertKey: insertKey,
+		AccountID: accountID,
+		Region:    region,
+		insert:    insertClient,
+		manager:   im,
+		stats:     stats,
+		breaker:   b,
+		isDefault: isDefault(insertKey),
+		batchSize: app.Get().Config.GetInt("NEWRELIC_BATCH_SIZE"),
+		queueSize: app.Get().Config.GetInt("NEWRELIC_QUEUE_SIZE"),
+		lastUsed:  time.Now().UnixNano(),
+		flush:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		limits:    newLimits(),
+		limited:   map[string]*limited{},
+	}
+	if c.batchSize < 1 {
+		c.batchSize = 1
+	}
+	c.spool = openSpool(insertKey)
+	go c.run()
+	return c
+}
+
+// EnqueueEventFrom the named accumulator, reported when the event exceeds
+// the attribute limits
+func (c *Client) EnqueueEventFrom(accumulator string, data interface{}) error {
+	return c.EnqueueEvent(sourced{accumulator: accumulator, event: data})
+}
+
+// EnqueueEvent for the next batch. Events enqueued on a closed Client go to
+// the Client that replaced it. While the account's breaker is open events go
+// to the default account, or are dropped, per NEWRELIC_BREAKER_ACTION.
+func (c *Client) EnqueueEvent(data interface{}) error {
+	if c.breaker != nil && !c.breaker.allow() {
+		return c.divert([]interface{}{data})
+	}
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return c.manager.Get(c.InsertKey, c.AccountID, c.Region).EnqueueEvent(data)
+	}
+	atomic.StoreInt64(&c.lastUsed, time.Now().UnixNano())
+	if c.queueSize > 0 && len(c.events) >= c.queueSize {
+		c.lock.Unlock()
+		atomic.AddInt64(&c.stats.Dropped, 1)
+		return errQueueFull
+	}
+	c.events = append(c.events, data)
+	full := len(c.events) >= c.batchSize
+	c.lock.Unlock()
+
+	atomic.AddInt64(&c.stats.Queued, 1)
+	if full {
+		c.Flush()
+	}
+	return nil
+}
+
+// Flush posts the queued events in the background
+func (c *Client) Flush() {
+	select {
+	case c.flush <- struct{}{}:
+	default:
+	}
+}
+
+// Close posts the queued events and stops the Client
+func (c *Client) Close() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		<-c.stopped
+		return
+	}
+	c.closed = true
+	c.lock.Unlock()
+	close(c.done)
+	<-c.stopped
+}
+
+// idle reports whether no event was enqueued within ttl
+func (c *Client) idle(ttl time.Duration) bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.lastUsed))) > ttl
+}
+
+func (c *Client) run() {
+	defer close(c.stopped)
+	var retry <-chan time.Time
+	if c.spool != nil {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		retry = ticker.C
+	}
+	for {
+		select {
+		case <-c.flush:
+			c.post()
+		case <-retry:
+			c.replay()
+		case <-c.done:
+			c.post()
+			if c.spool != nil {
+				c.spool.Close()
+			}
+			return
+		}
+	}
+}
+
+// post every queued event, batchSize events at a time, in payloads within
+// the Event API size limits
+func (c *Client) post() {
+	defer c.logLimited()
+	c.replay()
+	for {
+		batch := c.take()
+		if len(batch) == 0 {
+			return
+		}
+		events := make([][]byte, 0, len(batch))
+		for _, event := range batch {
+			b, err := c.encode(event)
+			if err != nil {
+				app.Get().Log.Warnf("unable to encode event for account %s: %s", c.AccountID, err.Error())
+				atomic.AddInt64(&c.stats.Dropped, 1)
+				continue
+			}
+			events = append(events, b)
+		}
+		payloads, counts := c.pack(events)
+		for i, payload := range payloads {
+			c.send(payload, counts[i])
+		}
+	}
+}
+
+// send a payload, spooling it while older payloads wait in the spool
+func (c *Client) send(payload json.RawMessage, events int) {
+	// the breaker opened while the queue was posted
+	if c.breaker != nil && c.breaker.isOpen() {
+		c.divertPayload(payload, events)
+		return
+	}
+	if c.spool != nil && (c.spool.Events() > 0 || time.Now().Before(c.retryAt)) {
+		c.toSpool(payload, events)
+		return
+	}
+	err := c.postEvent(payload)
+	c.record(err)
+	if err == nil {
+		atomic.AddInt64(&c.stats.Posted, int64(events))
+		return
+	}
+	app.Get().Log.Warnf("unable to post %d events to account %s: %s", events, c.AccountID, err.Error())
+	if c.spool != nil && retryable(err) {
+		c.backoff()
+		c.toSpool(payload, events)
+		return
+	}
+	atomic.AddInt64(&c.stats.Failed, int64(events))
+}
+
+func (c *Client) toSpool(payload []byte, events int) {
+	dropped, err := c.spool.Write(payload, int64(events))
+	if err != nil {
+		app.Get().Log.Warnf("unable to spool %d events of account %s: %s", events, c.AccountID, err.Error())
+		atomic.AddInt64(&c.stats.Failed, int64(events))
+		return
+	}
+	atomic.AddInt64(&c.stats.Spooled, int64(events))
+	if dropped > 0 {
+		app.Get().Log.Warnf("spool of account %s is full, dropped %d of the oldest events", c.AccountID, dropped)
+		atomic.AddInt64(&c.stats.SpoolDropped, dropped)
+	}
+}
+
+// replay spooled batches in order once the retry delay has passed
+func (c *Client) replay() {
+	if c.spool == nil {
+		return
+	}
+	for !time.Now().Before(c.retryAt) {
+		batch, events, ok, err := c.spool.Peek()
+		if err != nil {
+			app.Get().Log.Warnf("unable to read spool of account %s: %s", c.AccountID, err.Error())
+			c.backoff()
+			return
+		}
+		if !ok {
+			return
+		}
+		err = c.postEvent(batch)
+		c.record(err)
+		if err != nil {
+			if retryable(err) {
+				c.backoff()
+				return
+			}
+			// rejected batches are not retried
+			app.Get().Log.Warnf("dropping %d spooled events of account %s: %s", events, c.AccountID, err.Error())
+			atomic.AddInt64(&c.stats.Failed, events)
+		} else {
+			atomic.AddInt64(&c.stats.Posted, events)
+			atomic.AddInt64(&c.stats.Replayed, events)
+			c.retryDelay = 0
+		}
+		c.spool.Commit()
+	}
+}
+
+// backoff doubles the retry delay between NEWRELIC_SPOOL_RETRY_MIN and _MAX
+func (c *Client) backoff() {
+	min := app.Get().Config.GetDuration("NEWRELIC_SPOOL_RETRY_MIN")
+	max := app.Get().Config.GetDuration("NEWRELIC_SPOOL_RETRY_MAX")
+	c.retryDelay *= 2
+	if c.retryDelay < min {
+		c.retryDelay = min
+	}
+	if c.retryDelay > max {
+		c.retryDelay = max
+	}
+	c.retryAt = time.Now().Add(c.retryDelay)
+}
+
+// statusPattern matches the response status in go-insights post errors, the
+// postEvent tests pin the format
+var statusPattern = regexp.MustCompile(`(?i)bad response from insights: (\d{3})\b`)
+
+// postError of a post, Status is 0 when no response was received
+type postError struct {
+	Status int
+	err    error
+}
+
+func (e *postError) Error() string {
+	return e.err.Error()
+}
+
+func (e *postError) Unwrap() error {
+	return e.err
+}
+
+// postEvent posts a payload, returning a *postError when it fails
+func (c *Client) postEvent(payload json.RawMessage) error {
+	if err := c.insert.PostEvent(payload); err != nil {
+		return newPostError(err)
+	}
+	return nil
+}
+
+// newPostError reads the response status of a go-insights error, network
+// errors have none
+func newPostError(err error) *postError {
+	e := &postError{err: err}
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) {
+		return e
+	}
+	if m := statusPattern.FindStringSubmatch(err.Error()); m != nil {
+		e.Status, _ = strconv.Atoi(m[1])
+	}
+	return e
+}
+
+// status of the response to a failed post, 0 when there was none
+func status(err error) int {
+	var e *postError
+	if errors.As(err, &e) {
+		return e.Status
+	}
+	return 0
+}
+
+// retryable errors are network errors, 5xx responses, timeouts and throttling,
+// other 4xx responses reject the batch.
+func retryable(err error) bool {
+	s := status(err)
+	return s == 0 || s >= 500 || s == 408 || s == 429
+}
+
+// openSpool of an insert key under NEWRELIC_SPOOL_DIR, nil when spooling is disabled.
+// Directories are named by a hash of the key, keys are never written to disk.
+func openSpool(insertKey string) *spool.Spool {
+	dir := app.Get().Config.GetString("NEWRELIC_SPOOL_DIR")
+	if len(dir) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(insertKey))
+	s, err := spool.Open(
+		filepath.Join(dir, hex.EncodeToString(sum[:8])),
+		app.Get().Config.GetInt64("NEWRELIC_SPOOL_SEGMENT_SIZE_MB")<<20,
+		app.Get().Config.GetInt64("NEWRELIC_SPOOL_MAX_SIZE_MB")<<20,
+	)
+	if err != nil {
+		app.Get().Log.Warnf("unable to open spool, failed posts will be dropped: %s", err.Error())
+		return nil
+	}
+	return s
+}
+
+// takeAll queued events
+func (c *Client) takeAll() []interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	events := c.events
+	c.events = nil
+	return events
+}
+
+func (c *Client) take() (batch []interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n := len(c.events)
+	if n > c.batchSize {
+		n = c.batchSize
+	}
+	batch = c.events[:n:n]
+	if n == len(c.events) {
+		c.events = nil
+	} else {
+		c.events = c.events[n:]
+	}
+	return batch
+}
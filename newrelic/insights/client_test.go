@@ -0,0 +1,107 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package insights
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/newrelic/go-insights/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func response(status int) error {
+	return fmt.Errorf("Bad response from Insights: %d \n\t{\"error\": \"rejected\"}", status)
+}
+
+var _ = Describe("retryable", func() {
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	for _, c := range []struct {
+		name      string
+		err       error
+		status    int
+		retryable bool
+		breaks    bool
+	}{
+		{"retries server errors", response(500), 500, true, true},
+		{"retries unavailable", response(503), 503, true, true},
+		{"retries timeouts", response(408), 408, true, false},
+		{"retries throttling", response(429), 429, true, false},
+		{"rejects bad requests", response(400), 400, false, false},
+		{"rejects payloads too large", response(413), 413, false, false},
+		{"rejects forbidden keys", response(403), 403, false, true},
+		{"retries network errors", dial, 0, true, false},
+		{"retries URL errors with a port", &url.Error{Op: "Post", URL: "https://insights-collector.newrelic.com:443/v1/accounts/1/events", Err: dial}, 0, true, false},
+		{"retries wrapped errors mentioning a port", fmt.Errorf("failed to send request: dial tcp 10.0.0.1:443: i/o timeout"), 0, true, false},
+		{"ignores numbers in the response body", fmt.Errorf("Bad response from Insights: 502 \n\t{\"code\": 404}"), 502, true, true},
+	} {
+		c := c
+		It(c.name, func() {
+			err := newPostError(c.err)
+			Expect(status(err)).To(Equal(c.status))
+			Expect(retryable(err)).To(Equal(c.retryable))
+			s, breaks := breaks(err)
+			Expect(s).To(Equal(c.status))
+			Expect(breaks).To(Equal(c.breaks))
+		})
+	}
+
+	It("keeps the message and cause", func() {
+		err := newPostError(dial)
+		Expect(err.Error()).To(Equal(dial.Error()))
+		Expect(errors.Is(err, dial)).To(BeTrue())
+	})
+})
+
+// the breaker and spool read the status from the go-insights error message,
+// these pin its format
+var _ = Describe("postEvent", func() {
+	var server *httptest.Server
+	var code int
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+			if code == http.StatusOK {
+				w.Write([]byte(`{"success":true}`))
+				return
+			}
+			w.Write([]byte(`{"error":"rejected","code":404}`))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	post := func() error {
+		insert := client.NewInsertClient("key", "1")
+		insert.UseCustomURL(server.URL)
+		c := &Client{insert: insert}
+		return c.postEvent(json.RawMessage(`[{"eventType":"Test"}]`))
+	}
+
+	for _, s := range []int{400, 403, 413, 429, 500, 503} {
+		s := s
+		It(fmt.Sprintf("reads the %d status of go-insights errors", s), func() {
+			code = s
+			err := post()
+			Expect(err).To(HaveOccurred())
+			Expect(status(err)).To(Equal(s))
+		})
+	}
+
+	It("succeeds on 200", func() {
+		code = http.StatusOK
+		Expect(post()).To(Succeed())
+	})
+})
@@ -4,12 +4,11 @@
 package insights
 
 import (
-	"os"
+	"context"
 	"sync"
+	"time"
 
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
-
-	"github.com/newrelic/go-insights/client"
 )
 
 var once sync.Once
@@ -19,21 +18,26 @@ var instance *InsertManager
 func New() *InsertManager {
 	once.Do(func() {
 		instance = &InsertManager{
-			collection: map[string]*client.InsertClient{},
+			collection: map[string]*Client{},
+			accounts:   map[string]*Stats{},
+			breakers:   map[string]*breaker{},
 			sync:       &sync.RWMutex{},
 		}
 	})
 	return instance
 }
 
-// InsertManager ...
+// InsertManager keeps a Client per insert key, closing Clients that were not
+// used for NEWRELIC_CLIENT_IDLE_TTL.
 type InsertManager struct {
-	collection map[string]*client.InsertClient
+	collection map[string]*Client
+	accounts   map[string]*Stats
+	breakers   map[string]*breaker
 	sync       *sync.RWMutex
 }
 
 // Has ...
-func (im *InsertManager) Has(insertKey string) (c *client.InsertClient, ok bool) {
+func (im *InsertManager) Has(insertKey string) (c *Client, ok bool) {
 	im.sync.RLock()
 	defer im.sync.RUnlock()
 	c, ok = im.collection[insertKey]
@@ -41,39 +45,153 @@ func (im *InsertManager) Has(insertKey string) (c *client.InsertClient, ok bool)
 }
 
 // Put ...
-func (im *InsertManager) Put(insertKey string, c *client.InsertClient) {
+func (im *InsertManager) Put(insertKey string, c *Client) {
 	im.sync.Lock()
 	im.collection[insertKey] = c
 	im.sync.Unlock()
 }
 
 // New ...
-func (im *InsertManager) New(insightsInsertKey string, rpmAccountID string, accountRegion string) *client.InsertClient {
-	insertClient := client.NewInsertClient(insightsInsertKey, rpmAccountID)
-	insertClient.Logger.Out = os.Stdout
-	insertClient.SetCompression(client.Gzip) //always use compression to Insights
-	if accountRegion == "EU" {
-		//UseCustomURL only sets the host (domain) of the URL
-		insertClient.UseCustomURL(app.Get().Config.GetString("NEWRELIC_EU_BASE_URL"))
-	}
-	insertClient.Start()
+func (im *InsertManager) New(insightsInsertKey string, rpmAccountID string, accountRegion string) *Client {
 	im.sync.Lock()
-	im.collection[insightsInsertKey] = insertClient
-	im.sync.Unlock()
-	return insertClient
+	defer im.sync.Unlock()
+	// another caller may have created it meanwhile
+	if c, ok := im.collection[insightsInsertKey]; ok {
+		return c
+	}
+	stats, found := im.accounts[rpmAccountID]
+	if !found {
+		stats = &Stats{}
+		im.accounts[rpmAccountID] = stats
+	}
+	// the default account has no breaker, events fall back to it
+	var b *breaker
+	if !isDefault(insightsInsertKey) {
+		if b, found = im.breakers[rpmAccountID]; !found {
+			if b = newBreaker(); b != nil {
+				im.breakers[rpmAccountID] = b
+			}
+		}
+	}
+	c := newClient(im, insightsInsertKey, rpmAccountID, accountRegion, stats, b)
+	im.collection[insightsInsertKey] = c
+	return c
 }
 
 // Get ...
-func (im *InsertManager) Get(insightsInsertKey string, rpmAccountID string, accountRegion string) *client.InsertClient {
+func (im *InsertManager) Get(insightsInsertKey string, rpmAccountID string, accountRegion string) *Client {
 	if c, ok := im.Has(insightsInsertKey); ok {
 		return c
 	}
 	return im.New(insightsInsertKey, rpmAccountID, accountRegion)
 }
 
-// FlushAll clients
-func (im *InsertManager) FlushAll() {
+// Default Client of the main account (from the config file)
+func (im *InsertManager) Default() *Client {
+	return im.Get(app.Get().Config.GetNewRelicConfig())
+}
+
+// clients returns a copy of the collection
+func (im *InsertManager) clients() []*Client {
+	im.sync.RLock()
+	defer im.sync.RUnlock()
+	list := make([]*Client, 0, len(im.collection))
 	for _, c := range im.collection {
+		list = append(list, c)
+	}
+	return list
+}
+
+// FlushAll clients, and close clients idle for NEWRELIC_CLIENT_IDLE_TTL
+func (im *InsertManager) FlushAll() {
+	for _, c := range im.clients() {
 		c.Flush()
 	}
+	if ttl := app.Get().Config.GetDuration("NEWRELIC_CLIENT_IDLE_TTL"); ttl > 0 {
+		im.evictIdle(ttl)
+	}
+}
+
+func (im *InsertManager) evictIdle(ttl time.Duration) {
+	for _, c := range im.clients() {
+		if c.idle(ttl) && !isDefault(c.InsertKey) {
+			app.Get().Log.Debugf("closing idle insert client of account %s", c.AccountID)
+			im.remove(c)
+		}
+	}
+}
+
+// Retire the Client of a replaced insert key, once its queued events are posted.
+// The key gets a new Client if it is still used.
+func (im *InsertManager) Retire(insertKey string) {
+	if isDefault(insertKey) {
+		return
+	}
+	if c, ok := im.Has(insertKey); ok {
+		app.Get().Log.Infof("retiring replaced insert key of account %s", c.AccountID)
+		im.remove(c)
+	}
+}
+
+// remove the Client from the collection and close it in the background
+func (im *InsertManager) remove(c *Client) {
+	im.sync.Lock()
+	if im.collection[c.InsertKey] == c {
+		delete(im.collection, c.InsertKey)
+	}
+	im.sync.Unlock()
+	go c.Close()
+}
+
+// CloseAll clients, posting their queued events, until ctx is done
+func (im *InsertManager) CloseAll(ctx context.Context) error {
+	im.sync.Lock()
+	list := make([]*Client, 0, len(im.collection))
+	for _, c := range im.collection {
+		list = append(list, c)
+	}
+	im.collection = map[string]*Client{}
+	im.sync.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wg := sync.WaitGroup{}
+		for _, c := range list {
+			wg.Add(1)
+			go func(c *Client) {
+				defer wg.Done()
+				c.Close()
+			}(c)
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats of each account by account ID
+func (im *InsertManager) Stats() map[string]Stats {
+	im.sync.RLock()
+	defer im.sync.RUnlock()
+	stats := make(map[string]Stats, len(im.accounts))
+	for id, s := range im.accounts {
+		stats[id] = s.snapshot()
+		if b, found := im.breakers[id]; found {
+			st := stats[id]
+			st.BreakerOpen = b.isOpen()
+			stats[id] = st
+		}
+	}
+	return stats
+}
+
+// isDefault reports whether the key is the main account key (from the config file)
+func isDefault(insertKey string) bool {
+	key, _, _ := app.Get().Config.GetNewRelicConfig()
+	return insertKey == key
 }
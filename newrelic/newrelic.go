@@ -4,6 +4,7 @@
 package newrelic
 
 import (
+	"context"
 	"os"
 	"time"
 
@@ -11,6 +12,8 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/healthcheck"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/insights"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/registry"
 )
 
@@ -33,6 +36,11 @@ func Start(interupt <-chan os.Signal) {
 
 	app := app.Get()
 
+	insights.AgentAttributes = func() map[string]interface{} {
+		return nrpcf.AgentAttributes().Marshal()
+	}
+	cfapps.Removed = nrpcf.ForgetApp
+
 	nr := &NewRelic{
 		App:          app,
 		CFAppManager: cfapps.Start(app),
@@ -44,6 +52,10 @@ func Start(interupt <-chan os.Signal) {
 	nr.Router = NewRouter(nr.Firehose, nr.Collector)
 	nr.Router.Start()
 	nr.Harvester = NewHarvester(nr.Collector)
+	if app.Config.GetBool("NEWRELIC_HEALTH_EVENTS") {
+		nr.Harvester.health = newHealth(nr.CFAppManager)
+	}
+	nr.registerHealthChecks()
 	healthcheck.Start()
 
 	for {
@@ -51,9 +63,7 @@ func Start(interupt <-chan os.Signal) {
 
 		case <-interupt:
 			app.Log.Info("interupt received, gracefully closing...")
-			nr.Firehose.Close()
-			nr.Router.Close()
-			app.WaitGroup.Wait()
+			nr.Shutdown()
 			app.Log.Info("closed New Relic")
 			return
 
@@ -68,6 +78,35 @@ func Start(interupt <-chan os.Signal) {
 	}
 }
 
+// Shutdown stops ingestion, routes the envelopes still buffered, harvests once
+// more and posts every queued event, within SHUTDOWN_TIMEOUT.
+func (nr *NewRelic) Shutdown() {
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		nr.App.Config.GetDuration("SHUTDOWN_TIMEOUT"),
+	)
+	defer cancel()
+
+	nr.Harvest.Stop()
+	nr.Firehose.Close()
+	nr.Router.Close(ctx)
+	nr.Harvester.Harvest()
+	if err := insights.New().CloseAll(ctx); err != nil {
+		nr.App.Log.Warnf("shutdown deadline reached before all events were posted: %s", err.Error())
+	}
+	nr.CFAppManager.Close()
+
+	done := make(chan struct{})
+	go func() {
+		nr.App.WaitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
 func harvestConfig(app *app.Application) *time.Ticker {
 	return time.NewTicker(
 		app.Config.GetDuration("NEWRELIC_DRAIN_INTERVAL"),
@@ -0,0 +1,51 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpcf
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("bindingKey", func() {
+	BeforeEach(func() {
+		bindingKeysLock.Lock()
+		bindingKeys = map[string]string{}
+		bindingKeysLock.Unlock()
+	})
+
+	It("retires nothing for new and unchanged keys", func() {
+		_, retire := bindingKey("app-1", "key-a")
+		Expect(retire).To(BeFalse())
+		_, retire = bindingKey("app-1", "key-a")
+		Expect(retire).To(BeFalse())
+	})
+
+	It("retires a replaced key no other app uses", func() {
+		bindingKey("app-1", "key-a")
+		previous, retire := bindingKey("app-1", "key-b")
+		Expect(retire).To(BeTrue())
+		Expect(previous).To(Equal("key-a"))
+	})
+
+	It("keeps a replaced key other apps still use", func() {
+		bindingKey("app-1", "key-a")
+		bindingKey("app-2", "key-a")
+		_, retire := bindingKey("app-1", "key-b")
+		Expect(retire).To(BeFalse())
+
+		_, retire = bindingKey("app-2", "key-b")
+		Expect(retire).To(BeTrue())
+	})
+
+	It("forgets removed apps", func() {
+		bindingKey("app-1", "key-a")
+		bindingKey("app-2", "key-a")
+		ForgetApp("app-2")
+		Expect(bindingKeys).To(Equal(map[string]string{"app-1": "key-a"}))
+
+		_, retire := bindingKey("app-1", "key-b")
+		Expect(retire).To(BeTrue())
+	})
+})
@@ -4,18 +4,22 @@
 package nrpcf
 
 import (
+	"fmt"
 	"net/url"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
-	"github.com/newrelic/go-insights/client"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/insights"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/routing"
 )
 
 var cfg = config.Get()
@@ -57,6 +61,14 @@ func GetPCFAttributes(e *loggregator_v2.Envelope) *attributes.Attributes {
 			attrs.Append(a)
 		}
 	}
+	attrs.AppendAll(AgentAttributes())
+	return attrs
+}
+
+// AgentAttributes describes the foundation and nozzle instance,
+// for events that are not built from an envelope.
+func AgentAttributes() *attributes.Attributes {
+	attrs := attributes.NewAttributes()
 	attrs.SetAttribute(domain, PCFDomain())
 	attrs.SetAttribute(cfg.GetString(config.EnvDomainAlias), PCFDomain())
 	attrs.SetAttribute("agent.version", cfg.GetString("Version"))
@@ -127,73 +139,210 @@ func GetLicenseKey(credentials map[string]interface{}) (string, bool) {
 	return licenseKey, found
 }
 
+var (
+	routes     *routing.Table
+	routesOnce sync.Once
+)
+
+// Routes loads the ACCOUNT_ROUTES table once, nil when none is configured
+func Routes() *routing.Table {
+	routesOnce.Do(func() {
+		t, err := routing.FromConfig(app.Get().Config, "ACCOUNT_ROUTES")
+		if err != nil {
+			app.Get().Log.Fatalf("invalid ACCOUNT_ROUTES: %s", err.Error())
+		}
+		if t != nil {
+			app.Get().Log.Infof("loaded %d account routes", t.Length())
+		}
+		routes = t
+	})
+	return routes
+}
+
+// RouteApp returns the account the first matching route sends the app's
+// events to, or nil when no route matches.
+func RouteApp(cfapp *cfapps.CFApp) *routing.Account {
+	t := Routes()
+	if t == nil {
+		return nil
+	}
+	attrs := cfapp.GetAttributes()
+	return t.Match(func(name string) (string, bool) {
+		if attr := attrs.Has(name); attr != nil {
+			return fmt.Sprint(attr.Value()), true
+		}
+		return "", false
+	})
+}
+
+// platformTags resolved for platform routes
+var platformTags = map[string]string{
+	"deployment": deployment,
+	"job":        job,
+	"origin":     origin,
+	"index":      index,
+	"ip":         ip,
+}
+
+// GetInsertClientForPlatform returns the client of the account the first matching
+// platform route sends events of the entity's deployment and job to,
+// or the main account (from the config file).
+func GetInsertClientForPlatform(e *entities.Entity) *insights.Client {
+	im := insights.New()
+	if t := Routes(); t != nil {
+		account := t.MatchPlatform(func(name string) (string, bool) {
+			if attr := e.AttributeByName(platformTags[name]); attr != nil {
+				return fmt.Sprint(attr.Value()), true
+			}
+			return "", false
+		})
+		if account != nil {
+			return im.Get(account.InsertKey, account.AccountID, account.Region)
+		}
+	}
+	return im.Get(app.Get().Config.GetNewRelicConfig())
+}
+
 // GetInsertClientForApp checks app for newrelic plan sub-account insert creds
 // and return insight client from insert manager/cache or new.
 // If app does not have a plan, this returns the main account credentials (from the config file)
-func GetInsertClientForApp(e *entities.Entity) (c *client.InsertClient) {
+func GetInsertClientForApp(e *entities.Entity) (c *insights.Client) {
 
 	guid := e.AttributeByName(config.Get().AttributeName(config.EnvAppID)).Value()
 	cfapp := cfapps.GetInstance().GetApp(guid.(string))
 	im := insights.New()
 
+	// Routed apps go to their account, ahead of the service binding credentials
+	if account := RouteApp(cfapp); account != nil {
+		return im.Get(account.InsertKey, account.AccountID, account.Region)
+	}
+
 	cfapp.Lock.RLock()
 	vcap := cfapp.VcapServices
 	cfapp.Lock.RUnlock()
 
-	if vcap == nil {
-		defaultClient := im.Get(app.Get().Config.GetNewRelicConfig())
-		return defaultClient
-	}
-
-	//Can do this if newrelic isn't found, but also need to check for rpmAccountId and insightsInsertKey values
-	if _, found := vcap["newrelic"]; !found {
-		defaultClient := im.Get(app.Get().Config.GetNewRelicConfig())
-		return defaultClient
+	if insertKey, rpmId, accountRegion, found := BindingCredentials(vcap); found {
+		// A replaced key in the binding retires the client of the old key
+		if previous, retire := bindingKey(cfapp.GUID, insertKey); retire {
+			im.Retire(previous)
+		}
+		// Call Get from Insights manager to get a client with this configuration.
+		return im.Get(insertKey, rpmId, accountRegion)
 	}
+	return im.Get(app.Get().Config.GetNewRelicConfig())
 
-	newrelicSlice := vcap["newrelic"].([]interface{})
-	newrelic := newrelicSlice[0].(map[string]interface{})
+}
 
-	// Get the credentials map from inside of the newrelic map, if it exists.
-	if _, found := newrelic["credentials"].(map[string]interface{}); !found {
-		defaultClient := im.Get(app.Get().Config.GetNewRelicConfig())
-		return defaultClient
-	}
-	credentials := newrelic["credentials"].(map[string]interface{})
+// bindingKeys holds the binding insert key last used for each app GUID
+var (
+	bindingKeys     = map[string]string{}
+	bindingKeysLock sync.Mutex
+)
 
-	// Call GetInsertKey
-	insertKey, found := GetInsertKey(credentials)
-	if !found {
-		defaultClient := im.Get(app.Get().Config.GetNewRelicConfig())
-		return defaultClient
+// bindingKey records the binding insert key of an app, returning the key it
+// replaced when no other app is bound with it anymore
+func bindingKey(guid string, insertKey string) (previous string, retire bool) {
+	bindingKeysLock.Lock()
+	defer bindingKeysLock.Unlock()
+	previous, found := bindingKeys[guid]
+	bindingKeys[guid] = insertKey
+	if !found || previous == insertKey {
+		return previous, false
 	}
-	// Call GetRpmId
-	rpmId, found := GetRpmId(credentials)
-	if !found {
-		defaultClient := im.Get(app.Get().Config.GetNewRelicConfig())
-		return defaultClient
+	for _, key := range bindingKeys {
+		if key == previous {
+			return previous, false
+		}
 	}
+	return previous, true
+}
 
-	// Call GetLicenseKey
-	licenseKey, found := GetLicenseKey(credentials)
-	if !found {
-		defaultClient := im.Get(app.Get().Config.GetNewRelicConfig())
-		return defaultClient
-	}
+// ForgetApp drops the binding insert key of an app deleted or evicted from
+// the cache, its client is closed once idle
+func ForgetApp(guid string) {
+	bindingKeysLock.Lock()
+	delete(bindingKeys, guid)
+	bindingKeysLock.Unlock()
+}
 
-	isEU := strings.HasPrefix(licenseKey, "eu01x")
-	var accountRegion string
-	if isEU {
-		accountRegion = "EU"
-	} else {
-		accountRegion = "US"
-	}
+// serviceBinding of VCAP_SERVICES
+type serviceBinding struct {
+	rank        int
+	name        string
+	credentials map[string]interface{}
+}
 
-	// Call Get from Insights manager to get a client with this configuration.
-	c = im.Get(insertKey, rpmId, accountRegion)
+var (
+	serviceNames     *regexp.Regexp
+	serviceNamesOnce sync.Once
+)
 
-	return c
+// userProvidedNames compiles ACCOUNT_SERVICE_NAME_PATTERN once
+func userProvidedNames() *regexp.Regexp {
+	serviceNamesOnce.Do(func() {
+		re, err := regexp.Compile(app.Get().Config.GetString("ACCOUNT_SERVICE_NAME_PATTERN"))
+		if err != nil {
+			app.Get().Log.Fatalf("invalid ACCOUNT_SERVICE_NAME_PATTERN: %s", err.Error())
+		}
+		serviceNames = re
+	})
+	return serviceNames
+}
+
+// BindingCredentials returns the account of the first binding with an insert key
+// and account ID. Bindings are ordered by their label in ACCOUNT_SERVICE_LABELS,
+// then by service name, so the choice does not depend on the order of VCAP_SERVICES.
+// user-provided services are only used when their name matches ACCOUNT_SERVICE_NAME_PATTERN.
+func BindingCredentials(vcap map[string]interface{}) (insertKey string, rpmId string, region string, found bool) {
+	var bindings []*serviceBinding
+	for rank, label := range app.Get().Config.GetFilter("ACCOUNT_SERVICE_LABELS") {
+		label = strings.TrimSpace(label)
+		list, _ := vcap[label].([]interface{})
+		for _, b := range list {
+			binding, ok := b.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := binding["name"].(string)
+			if label == "user-provided" && !userProvidedNames().MatchString(name) {
+				continue
+			}
+			credentials, ok := binding["credentials"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			bindings = append(bindings, &serviceBinding{rank: rank, name: name, credentials: credentials})
+		}
+	}
+	sort.SliceStable(bindings, func(i, j int) bool {
+		if bindings[i].rank != bindings[j].rank {
+			return bindings[i].rank < bindings[j].rank
+		}
+		return bindings[i].name < bindings[j].name
+	})
+
+	for _, b := range bindings {
+		if insertKey, found = GetInsertKey(b.credentials); !found {
+			continue
+		}
+		if rpmId, found = GetRpmId(b.credentials); !found {
+			continue
+		}
+		return insertKey, rpmId, GetRegion(b.credentials), true
+	}
+	return "", "", "", false
+}
 
+// GetRegion from the credentials map, from the EU license key prefix
+// when no region is given.
+func GetRegion(credentials map[string]interface{}) string {
+	if region, found := credentials["region"].(string); found && strings.EqualFold(region, "EU") {
+		return "EU"
+	}
+	if licenseKey, found := GetLicenseKey(credentials); found && strings.HasPrefix(licenseKey, "eu01x") {
+		return "EU"
+	}
+	return "US"
 }
 
 // isContainerMetric determines if the current v2 Gauge envelope is a v1 ContainerMetric or v1 ValueMetric
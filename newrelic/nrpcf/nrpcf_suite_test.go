@@ -0,0 +1,20 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpcf_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNrpcf(t *testing.T) {
+	os.Setenv("NRF_ACCOUNT_SERVICE_LABELS", "newrelic|user-provided")
+	os.Setenv("NRF_ACCOUNT_SERVICE_NAME_PATTERN", "^newrelic-")
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Nrpcf Suite")
+}
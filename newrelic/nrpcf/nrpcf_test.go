@@ -0,0 +1,109 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpcf_test
+
+import (
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func binding(name string, key string, account string) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name,
+		"credentials": map[string]interface{}{
+			"insightsInsertKey": key,
+			"rpmAccountId":      account,
+		},
+	}
+}
+
+var _ = Describe("BindingCredentials", func() {
+	for _, c := range []struct {
+		name    string
+		vcap    map[string]interface{}
+		account string
+		found   bool
+	}{
+		{
+			"finds nothing without bindings",
+			map[string]interface{}{},
+			"", false,
+		},
+		{
+			"uses a newrelic binding",
+			map[string]interface{}{"newrelic": []interface{}{binding("nr", "key", "1")}},
+			"1", true,
+		},
+		{
+			"orders bindings of a label by name",
+			map[string]interface{}{"newrelic": []interface{}{binding("nr-b", "key", "2"), binding("nr-a", "key", "1")}},
+			"1", true,
+		},
+		{
+			"prefers labels listed first",
+			map[string]interface{}{
+				"user-provided": []interface{}{binding("newrelic-a", "key", "2")},
+				"newrelic":      []interface{}{binding("nr-z", "key", "1")},
+			},
+			"1", true,
+		},
+		{
+			"skips bindings without an insert key",
+			map[string]interface{}{"newrelic": []interface{}{binding("nr-a", "", "1"), binding("nr-b", "key", "2")}},
+			"2", true,
+		},
+		{
+			"skips bindings without an account",
+			map[string]interface{}{"newrelic": []interface{}{binding("nr-a", "key", ""), binding("nr-b", "key", "2")}},
+			"2", true,
+		},
+		{
+			"uses user-provided services matching the name pattern",
+			map[string]interface{}{"user-provided": []interface{}{binding("newrelic-prod", "key", "3")}},
+			"3", true,
+		},
+		{
+			"ignores user-provided services not matching the name pattern",
+			map[string]interface{}{"user-provided": []interface{}{binding("mysql-newrelic", "key", "3")}},
+			"", false,
+		},
+		{
+			"ignores labels not listed",
+			map[string]interface{}{"p-mysql": []interface{}{binding("newrelic-db", "key", "4")}},
+			"", false,
+		},
+		{
+			"ignores malformed bindings",
+			map[string]interface{}{"newrelic": []interface{}{"nr", map[string]interface{}{"name": "nr", "credentials": "none"}}},
+			"", false,
+		},
+	} {
+		c := c
+		It(c.name, func() {
+			_, account, _, found := nrpcf.BindingCredentials(c.vcap)
+			Expect(found).To(Equal(c.found))
+			Expect(account).To(Equal(c.account))
+		})
+	}
+})
+
+var _ = Describe("GetRegion", func() {
+	for _, c := range []struct {
+		name        string
+		credentials map[string]interface{}
+		region      string
+	}{
+		{"defaults to US", map[string]interface{}{}, "US"},
+		{"uses the region", map[string]interface{}{"region": "eu"}, "EU"},
+		{"uses an EU license key", map[string]interface{}{"licenseKey": "eu01xx0000"}, "EU"},
+		{"ignores other license keys", map[string]interface{}{"licenseKey": "0000"}, "US"},
+	} {
+		c := c
+		It(c.name, func() {
+			Expect(nrpcf.GetRegion(c.credentials)).To(Equal(c.region))
+		})
+	}
+})
@@ -0,0 +1,83 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/healthcheck"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/insights"
+)
+
+// registerHealthChecks of the components for /health/ready. Only local wedge
+// conditions set readiness: no envelope received, or no harvest run, for too
+// long. The token, CF API and sink are reported as details.
+func (nr *NewRelic) registerHealthChecks() {
+	cfg := nr.App.Config
+
+	healthcheck.Register(healthcheck.Firehose, func() healthcheck.Component {
+		c := healthcheck.Track(healthcheck.Firehose).Component("connected")
+		age := time.Since(nr.Firehose.LastEnvelope())
+		c.Details["last_envelope_age_s"] = age.Seconds()
+		checkAge(&c, age, cfg.GetDuration("HEALTH_MAX_ENVELOPE_AGE"), "no envelope received")
+		return c
+	})
+
+	healthcheck.Register(healthcheck.Token, func() healthcheck.Component {
+		c := healthcheck.Track(healthcheck.Token).Component("valid")
+		return c
+	})
+
+	healthcheck.Register(healthcheck.CFAPI, func() healthcheck.Component {
+		c := healthcheck.Track(healthcheck.CFAPI).Component("reachable")
+		rate := nr.CFAppManager.RateStats()
+		c.Details["limiter_queued"] = rate.Queued
+		c.Details["limiter_timeouts"] = rate.Timeouts
+		return c
+	})
+
+	healthcheck.Register("harvest", func() healthcheck.Component {
+		last := nr.Harvester.Last()
+		age := time.Since(last.Time)
+		c := healthcheck.Component{Healthy: true, Details: map[string]interface{}{
+			"last_harvest":       last.Time.UTC().Format(time.RFC3339),
+			"last_harvest_age_s": age.Seconds(),
+			"duration_ms":        milliseconds(last.Duration),
+			"entities":           last.Entities,
+			"metrics":            last.Metrics,
+		}}
+		checkAge(&c, age, maxHarvestAge(cfg), "no harvest")
+		return c
+	})
+
+	healthcheck.Register(healthcheck.Sink, func() healthcheck.Component {
+		c := healthcheck.Track(healthcheck.Sink).Component("posting")
+		open := []string{}
+		failed := int64(0)
+		for id, stats := range insights.New().Stats() {
+			if stats.BreakerOpen {
+				open = append(open, id)
+			}
+			failed += stats.Failed
+		}
+		c.Details["breakers_open"] = open
+		c.Details["events_failed"] = failed
+		return c
+	})
+}
+
+// maxHarvestAge is HEALTH_HARVEST_INTERVALS missed harvests of NEWRELIC_DRAIN_INTERVAL
+func maxHarvestAge(cfg *config.Config) time.Duration {
+	return time.Duration(cfg.GetInt("HEALTH_HARVEST_INTERVALS")) * cfg.GetDuration("NEWRELIC_DRAIN_INTERVAL")
+}
+
+// checkAge marks the component unhealthy when age is over max, 0 disables it
+func checkAge(c *healthcheck.Component, age time.Duration, max time.Duration, reason string) {
+	if max > 0 && age > max {
+		c.Healthy = false
+		c.Reason = fmt.Sprintf("%s for %s", reason, age.Round(time.Second))
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/healthcheck"
+	"github.com/spf13/viper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("checkAge", func() {
+	for _, c := range []struct {
+		name    string
+		age     time.Duration
+		max     time.Duration
+		healthy bool
+	}{
+		{"is healthy within the limit", time.Minute, 5 * time.Minute, true},
+		{"is healthy at the limit", 5 * time.Minute, 5 * time.Minute, true},
+		{"is unhealthy past the limit", 6 * time.Minute, 5 * time.Minute, false},
+		{"is healthy when disabled", time.Hour, 0, true},
+	} {
+		c := c
+		It(c.name, func() {
+			component := healthcheck.Component{Healthy: true}
+			checkAge(&component, c.age, c.max, "no harvest")
+			Expect(component.Healthy).To(Equal(c.healthy))
+			if !c.healthy {
+				Expect(component.Reason).To(Equal("no harvest for " + c.age.String()))
+			}
+		})
+	}
+})
+
+var _ = Describe("maxHarvestAge", func() {
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = &config.Config{Viper: viper.New()}
+		cfg.Set("NEWRELIC_DRAIN_INTERVAL", "59s")
+	})
+
+	It("allows missed harvests of the drain interval", func() {
+		cfg.Set("HEALTH_HARVEST_INTERVALS", 5)
+		Expect(maxHarvestAge(cfg)).To(Equal(5 * 59 * time.Second))
+	})
+
+	It("follows the drain interval", func() {
+		cfg.Set("HEALTH_HARVEST_INTERVALS", 3)
+		cfg.Set("NEWRELIC_DRAIN_INTERVAL", "2m")
+		Expect(maxHarvestAge(cfg)).To(Equal(6 * time.Minute))
+	})
+
+	It("is disabled with 0 intervals", func() {
+		cfg.Set("HEALTH_HARVEST_INTERVALS", 0)
+		Expect(maxHarvestAge(cfg)).To(BeZero())
+	})
+
+	It("defaults to 5 intervals", func() {
+		Expect(config.Get().GetInt("HEALTH_HARVEST_INTERVALS")).To(Equal(5))
+	})
+})
@@ -0,0 +1,172 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package redact masks personal data and secrets before events are enqueued.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"gopkg.in/yaml.v2"
+)
+
+// Rule is a user defined mask, Replacement may reference submatches as ${1}
+type Rule struct {
+	Name        string `yaml:"name"`
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+type detector struct {
+	name        string
+	re          *regexp.Regexp
+	replacement string
+	valid       func(match string) bool
+}
+
+// builtins detectors, selected with REDACT_DETECTORS
+var builtins = map[string]*detector{
+	"email": {
+		re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	"jwt": {
+		re: regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`),
+	},
+	"bearer": {
+		re:          regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`),
+		replacement: "${1}[REDACTED:bearer]",
+	},
+	// bare digit runs are too often timestamps or IDs, a card number needs
+	// the usual grouping or a card field name in front of it
+	"card": {
+		re: regexp.MustCompile(
+			`(?i)(\b(?:[a-z]+[_.\-])*(?:(?:credit)?card|cc|pan)[a-z_.\- ]{0,20}["']?\s*[=:]\s*["']?)\d{13,19}\b` +
+				`|\b\d{4}(?:[ \-]\d{4}){2,3}(?:[ \-]\d{1,3})?\b` +
+				`|\b\d{4}[ \-]\d{6}[ \-]\d{4,5}\b`,
+		),
+		replacement: "${1}[REDACTED:card]",
+		valid:       luhn,
+	},
+	"aws": {
+		re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	},
+	"aws_secret": {
+		re:          regexp.MustCompile(`(?i)(aws_secret_access_key["']?\s*[=:]\s*["']?)[A-Za-z0-9/+=]{40}`),
+		replacement: "${1}[REDACTED:aws_secret]",
+	},
+}
+
+// Redactor applies detectors in order
+type Redactor struct {
+	detectors []*detector
+}
+
+// New Redactor from config, returns nil when redaction is disabled.
+func New(c *config.Config) (*Redactor, error) {
+	if !c.GetBool("REDACT_ENABLED") {
+		return nil, nil
+	}
+	r := &Redactor{}
+	for _, name := range c.GetFilter("REDACT_DETECTORS") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		d, found := builtins[name]
+		if !found {
+			return nil, fmt.Errorf("unknown redaction detector %q", name)
+		}
+		r.detectors = append(r.detectors, &detector{
+			name:        name,
+			re:          d.re,
+			replacement: replacement(name, d.replacement),
+			valid:       d.valid,
+		})
+	}
+	rules, err := loadRules(c)
+	if err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		if len(rule.Name) == 0 {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %s: %s", rule.Name, err.Error())
+		}
+		r.detectors = append(r.detectors, &detector{
+			name:        rule.Name,
+			re:          re,
+			replacement: replacement(rule.Name, rule.Replacement),
+		})
+	}
+	return r, nil
+}
+
+func replacement(name string, r string) string {
+	if len(r) > 0 {
+		return r
+	}
+	return fmt.Sprintf("[REDACTED:%s]", name)
+}
+
+func loadRules(c *config.Config) (rules []*Rule, err error) {
+	doc, err := c.GetDocument("REDACT_RULES")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read REDACT_RULES: %s", err.Error())
+	}
+	if err = yaml.Unmarshal(doc, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse redaction rules: %s", err.Error())
+	}
+	return rules, nil
+}
+
+// Redact returns s with every detected value masked, and the number of values masked.
+func (r *Redactor) Redact(s string) (string, int) {
+	count := 0
+	for _, d := range r.detectors {
+		s = d.re.ReplaceAllStringFunc(s, func(match string) string {
+			if d.valid != nil && !d.valid(match) {
+				return match
+			}
+			count++
+			return d.re.ReplaceAllString(match, d.replacement)
+		})
+	}
+	return s, count
+}
+
+// RedactQuery masks detected values in the query string of a URI only.
+func (r *Redactor) RedactQuery(uri string) (string, int) {
+	i := strings.Index(uri, "?")
+	if i < 0 {
+		return uri, 0
+	}
+	query, count := r.Redact(uri[i+1:])
+	return uri[:i+1] + query, count
+}
+
+// luhn validates a card number candidate, ignoring spaces and dashes
+func luhn(s string) bool {
+	sum := 0
+	digits := 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
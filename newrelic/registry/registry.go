@@ -9,6 +9,7 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/counter"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/http"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/logmessage"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/logmetric"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/value"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
 )
@@ -23,5 +24,6 @@ var Register = &Accumulators{
 	value.Metrics{},
 	capacity.Metrics{},
 	logmessage.Nrevents{},
+	logmetric.Metrics{},
 	http.Nrevents{},
 }
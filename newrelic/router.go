@@ -4,6 +4,7 @@
 package newrelic
 
 import (
+	"context"
 	"reflect"
 	"strings"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/telemetry"
 )
 
 // Streams ...
@@ -24,7 +26,8 @@ type Router struct {
 	Streams   Streams
 	Collector *Collector
 	ErrorChan chan error
-	closeChan chan bool
+	closeChan chan context.Context
+	stopped   chan struct{}
 	firehose  *firehose.Firehose
 }
 
@@ -36,7 +39,8 @@ func NewRouter(f *firehose.Firehose, c *Collector) *Router {
 		Streams:   Streams{},
 		Collector: c,
 		ErrorChan: make(chan error, 1),
-		closeChan: make(chan bool, 1),
+		closeChan: make(chan context.Context, 1),
+		stopped:   make(chan struct{}),
 		firehose:  f,
 	}
 
@@ -52,9 +56,14 @@ func NewRouter(f *firehose.Firehose, c *Collector) *Router {
 	return router
 }
 
-// Close Router
-func (r *Router) Close() {
-	r.closeChan <- true
+// Close Router once the envelopes left in the diode are routed, or ctx is done
+func (r *Router) Close(ctx context.Context) {
+	r.closeChan <- ctx
+	select {
+	case <-r.stopped:
+	case <-ctx.Done():
+		r.App.Log.Warn("shutdown deadline reached before the router was drained")
+	}
 }
 
 // Start Router
@@ -69,31 +78,28 @@ func (r *Router) Start() {
 
 			select {
 
-			case <-r.closeChan:
-				r.App.Log.Info("closed router")
+			case ctx := <-r.closeChan:
+				drained := 0
+				for ctx.Err() == nil {
+					e, notEmpty := r.Consumer.TryNext()
+					if !notEmpty {
+						break
+					}
+					r.route(e)
+					drained++
+				}
+				r.App.Log.Infof("closed router, routed %d remaining envelopes", drained)
+				close(r.stopped)
 				return
 
 			case err := <-r.ErrorChan:
-				r.App.Log.Errorf("Router error: %e", err.Error())
+				r.App.Log.Errorf("Router error: %s", err.Error())
 
 			default:
 				if e, notEmpty := r.Consumer.TryNext(); notEmpty {
 					// Reset the emptyDiodes count.  We found an envelope.
 					ed = 0
-
-					et := reflect.TypeOf(e.Message).String()
-					if et == "*loggregator_v2.Envelope_Gauge" {
-						if isContainerMetric(e) {
-							et = "ContainerMetric"
-						} else {
-							et = "ValueMetric"
-						}
-					}
-
-					for _, a := range r.Streams[et] {
-						r.App.Log.Tracer(">")
-						a.Update(e)
-					}
+					r.route(e)
 					continue
 				}
 				r.App.Log.Tracer("o")
@@ -112,6 +118,25 @@ func (r *Router) Start() {
 
 }
 
+// route an envelope to the accumulators of its stream
+func (r *Router) route(e *loggregator_v2.Envelope) {
+	start := time.Now()
+	defer func() { telemetry.Observe("router.dispatch", time.Since(start)) }()
+	et := reflect.TypeOf(e.Message).String()
+	if et == "*loggregator_v2.Envelope_Gauge" {
+		if isContainerMetric(e) {
+			et = "ContainerMetric"
+		} else {
+			et = "ValueMetric"
+		}
+	}
+
+	for _, a := range r.Streams[et] {
+		r.App.Log.Tracer(">")
+		a.Update(e)
+	}
+}
+
 // isContainerMetric determines if the current v2 Gauge envelope is a v1 ContainerMetric or v1 ValueMetric
 func isContainerMetric(e *loggregator_v2.Envelope) bool {
 	gauge := e.GetGauge()
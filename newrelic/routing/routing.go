@@ -0,0 +1,149 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package routing maps apps and platform components to named New Relic
+// accounts, configured from a YAML or JSON document. Routes match app
+// attributes, platform routes match BOSH tags of non-app envelopes, with the
+// same regular expressions as the rules engine. The first matching route wins.
+//
+//	accounts:
+//	  retail:
+//	    insert_key: NRII-...
+//	    account_id: "1234567"
+//	    region: EU
+//	routes:
+//	- name: retail-orgs
+//	  match:
+//	    app.org.name: "^retail-"
+//	  account: retail
+//	- name: checkout-team
+//	  match:
+//	    app.label.team: "^checkout$"
+//	  account: retail
+//	platform_routes:
+//	- name: retail-mysql
+//	  match:
+//	    deployment: "^pivotal-mysql-retail"
+//	  account: retail
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/rules"
+	"gopkg.in/yaml.v2"
+)
+
+// Account events are sent to
+type Account struct {
+	Name      string `yaml:"-"`
+	InsertKey string `yaml:"insert_key"`
+	AccountID string `yaml:"account_id"`
+	Region    string `yaml:"region"`
+}
+
+// Route matches attributes to an Account
+type Route struct {
+	rules.Rule `yaml:",inline"`
+	Account    string `yaml:"account"`
+	account    *Account
+}
+
+// Table of Routes evaluated in order
+type Table struct {
+	Accounts map[string]*Account `yaml:"accounts"`
+	Routes   []*Route            `yaml:"routes"`
+	// PlatformRoutes match deployment, job, origin, index and ip tags
+	PlatformRoutes []*Route `yaml:"platform_routes"`
+}
+
+// Parse a YAML or JSON routing document
+func Parse(doc []byte) (*Table, error) {
+	t := &Table{}
+	if err := yaml.Unmarshal(doc, t); err != nil {
+		return nil, fmt.Errorf("unable to parse routes: %s", err.Error())
+	}
+	if err := t.compile(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// FromConfig loads a routing document from the named config setting, or from
+// the file referenced by the same setting suffixed with _FILE.
+// Returns nil when neither is set.
+func FromConfig(c *config.Config, name string) (*Table, error) {
+	doc, err := c.GetDocument(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", name, err.Error())
+	}
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return Parse(doc)
+}
+
+func (t *Table) compile() error {
+	for name, a := range t.Accounts {
+		if a == nil || len(a.InsertKey) == 0 || len(a.AccountID) == 0 {
+			return fmt.Errorf("account %s: insert_key and account_id are required", name)
+		}
+		a.Name = name
+		a.Region = strings.ToUpper(a.Region)
+		switch a.Region {
+		case "":
+			a.Region = "US"
+		case "US", "EU":
+		default:
+			return fmt.Errorf("account %s: invalid region %q", name, a.Region)
+		}
+	}
+	if err := t.compileRoutes(t.Routes, "route"); err != nil {
+		return err
+	}
+	return t.compileRoutes(t.PlatformRoutes, "platform-route")
+}
+
+func (t *Table) compileRoutes(routes []*Route, prefix string) error {
+	for i, r := range routes {
+		if len(r.Name) == 0 {
+			r.Name = fmt.Sprintf("%s-%d", prefix, i)
+		}
+		a, found := t.Accounts[r.Account]
+		if !found {
+			return fmt.Errorf("route %s: unknown account %q", r.Name, r.Account)
+		}
+		r.account = a
+		if err := r.Compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match returns the Account of the first matching Route, or nil when none match.
+func (t *Table) Match(lookup rules.Lookup) *Account {
+	return match(t.Routes, lookup)
+}
+
+// MatchPlatform returns the Account of the first matching platform Route,
+// or nil when none match.
+func (t *Table) MatchPlatform(lookup rules.Lookup) *Account {
+	return match(t.PlatformRoutes, lookup)
+}
+
+func match(routes []*Route, lookup rules.Lookup) *Account {
+	for _, r := range routes {
+		if r.Matches(lookup) {
+			return r.account
+		}
+	}
+	return nil
+}
+
+// Length of the routing Table
+func (t *Table) Length() int {
+	return len(t.Routes) + len(t.PlatformRoutes)
+}
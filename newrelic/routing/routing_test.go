@@ -0,0 +1,174 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package routing_test
+
+import (
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/routing"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/rules"
+	"github.com/spf13/viper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func lookup(attrs map[string]string) rules.Lookup {
+	return func(name string) (string, bool) {
+		v, found := attrs[name]
+		return v, found
+	}
+}
+
+const accounts = `
+accounts:
+  retail:
+    insert_key: key-retail
+    account_id: "1"
+    region: eu
+  checkout:
+    insert_key: key-checkout
+    account_id: "2"
+`
+
+var _ = Describe("Parse", func() {
+	for _, c := range []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"accepts an empty document", ``, ""},
+		{"accepts JSON", `{"accounts": {"a": {"insert_key": "k", "account_id": "1"}}, "routes": [{"match": {"app.name": "^a"}, "account": "a"}]}`, ""},
+		{"rejects an unknown account", accounts + "routes:\n- name: r\n  account: missing\n  match:\n    app.name: a", `route r: unknown account "missing"`},
+		{"rejects an account without insert_key", "accounts:\n  a:\n    account_id: \"1\"", "account a: insert_key and account_id are required"},
+		{"rejects an account without account_id", "accounts:\n  a:\n    insert_key: k", "account a: insert_key and account_id are required"},
+		{"rejects an empty account", "accounts:\n  a:", "account a: insert_key and account_id are required"},
+		{"rejects an invalid region", "accounts:\n  a:\n    insert_key: k\n    account_id: \"1\"\n    region: APAC", `account a: invalid region "APAC"`},
+		{"rejects an invalid expression", accounts + "routes:\n- account: retail\n  match:\n    app.name: \"(\"", "error parsing regexp"},
+		{"rejects invalid documents", "routes: {", "unable to parse routes"},
+	} {
+		c := c
+		It(c.name, func() {
+			_, err := routing.Parse([]byte(c.doc))
+			if len(c.err) == 0 {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(ContainSubstring(c.err)))
+		})
+	}
+
+	It("normalises regions", func() {
+		t, err := routing.Parse([]byte(accounts))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Accounts["retail"].Region).To(Equal("EU"))
+		Expect(t.Accounts["checkout"].Region).To(Equal("US"))
+	})
+
+	It("names accounts and unnamed routes", func() {
+		t, err := routing.Parse([]byte(accounts + "routes:\n- account: retail\n  match:\n    app.name: a"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Accounts["retail"].Name).To(Equal("retail"))
+		Expect(t.Routes[0].Name).To(Equal("route-0"))
+	})
+})
+
+var _ = Describe("Match", func() {
+	var t *routing.Table
+
+	BeforeEach(func() {
+		var err error
+		t, err = routing.Parse([]byte(accounts + `
+routes:
+- name: checkout-team
+  match:
+    app.label.team: "^checkout$"
+  account: checkout
+- name: retail-orgs
+  match:
+    app.org.name: "^retail-"
+  account: retail
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Length()).To(Equal(2))
+	})
+
+	It("returns the account of a matching route", func() {
+		a := t.Match(lookup(map[string]string{"app.org.name": "retail-eu"}))
+		Expect(a).NotTo(BeNil())
+		Expect(a.Name).To(Equal("retail"))
+		Expect(a.InsertKey).To(Equal("key-retail"))
+	})
+
+	It("returns the first matching route", func() {
+		a := t.Match(lookup(map[string]string{"app.org.name": "retail-eu", "app.label.team": "checkout"}))
+		Expect(a.Name).To(Equal("checkout"))
+	})
+
+	It("returns nil when no route matches", func() {
+		Expect(t.Match(lookup(map[string]string{"app.org.name": "system"}))).To(BeNil())
+		Expect(t.Match(lookup(map[string]string{}))).To(BeNil())
+	})
+})
+
+var _ = Describe("MatchPlatform", func() {
+	var t *routing.Table
+
+	BeforeEach(func() {
+		var err error
+		t, err = routing.Parse([]byte(accounts + `
+routes:
+- match:
+    app.org.name: "^retail-"
+  account: retail
+platform_routes:
+- name: checkout-mysql
+  match:
+    deployment: "^pivotal-mysql-checkout"
+  account: checkout
+- name: mysql
+  match:
+    deployment: "^pivotal-mysql"
+  account: retail
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Length()).To(Equal(3))
+	})
+
+	It("returns the first matching platform route", func() {
+		Expect(t.MatchPlatform(lookup(map[string]string{"deployment": "pivotal-mysql-checkout-1"})).Name).To(Equal("checkout"))
+		Expect(t.MatchPlatform(lookup(map[string]string{"deployment": "pivotal-mysql-orders"})).Name).To(Equal("retail"))
+	})
+
+	It("keeps app and platform routes apart", func() {
+		Expect(t.MatchPlatform(lookup(map[string]string{"app.org.name": "retail-eu"}))).To(BeNil())
+		Expect(t.Match(lookup(map[string]string{"deployment": "pivotal-mysql-orders"}))).To(BeNil())
+	})
+
+	It("names unnamed platform routes", func() {
+		t, err := routing.Parse([]byte(accounts + "platform_routes:\n- account: retail\n  match:\n    job: router"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.PlatformRoutes[0].Name).To(Equal("platform-route-0"))
+	})
+
+	It("rejects platform routes to unknown accounts", func() {
+		_, err := routing.Parse([]byte(accounts + "platform_routes:\n- name: p\n  account: missing\n  match:\n    job: router"))
+		Expect(err).To(MatchError(`route p: unknown account "missing"`))
+	})
+})
+
+var _ = Describe("FromConfig", func() {
+	It("returns nil when unset", func() {
+		t, err := routing.FromConfig(&config.Config{Viper: viper.New()}, "ROUTES")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(BeNil())
+	})
+
+	It("parses the setting", func() {
+		c := &config.Config{Viper: viper.New()}
+		c.Set("ROUTES", accounts)
+		t, err := routing.FromConfig(c, "ROUTES")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Accounts).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,179 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package rules provides an ordered, regular expression based rule engine
+// that is configured from a YAML or JSON document.
+//
+//	default: keep
+//	rules:
+//	- name: drop-sandbox-noise
+//	  match:
+//	    app.org.name: "^sandbox$"
+//	    log.message: "(?i)health ?check"
+//	  action: drop
+//	- name: sample-router-logs
+//	  match:
+//	    source_type: "^RTR$"
+//	  action: sample
+//	  sample_rate: 0.1
+package rules
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"gopkg.in/yaml.v2"
+)
+
+// Action taken when a Rule matches
+type Action string
+
+// nolint
+const (
+	Keep   Action = "keep"
+	Drop   Action = "drop"
+	Sample Action = "sample"
+)
+
+// Lookup resolves an attribute name to its value, returning false when the
+// attribute is not available for the item being evaluated.
+type Lookup func(name string) (string, bool)
+
+// Rule matches attributes against regular expressions. All expressions in
+// Match must match for the Rule to apply.
+type Rule struct {
+	Name       string            `yaml:"name"`
+	Match      map[string]string `yaml:"match"`
+	Action     Action            `yaml:"action"`
+	SampleRate float64           `yaml:"sample_rate"`
+	matchers   map[string]*regexp.Regexp
+}
+
+// Set of Rules evaluated in order, the first matching Rule wins.
+type Set struct {
+	Default Action  `yaml:"default"`
+	Rules   []*Rule `yaml:"rules"`
+}
+
+// Parse a YAML or JSON rules document
+func Parse(doc []byte) (*Set, error) {
+	s := &Set{}
+	if err := yaml.Unmarshal(doc, s); err != nil {
+		return nil, fmt.Errorf("unable to parse rules: %s", err.Error())
+	}
+	if s.Default == "" {
+		s.Default = Keep
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FromConfig loads a rules document from the named config setting, or from
+// the file referenced by the same setting suffixed with _FILE.
+// Returns nil when neither is set.
+func FromConfig(c *config.Config, name string) (*Set, error) {
+	doc, err := c.GetDocument(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", name, err.Error())
+	}
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return Parse(doc)
+}
+
+func (s *Set) compile() error {
+	s.Default = Action(strings.ToLower(string(s.Default)))
+	if !validAction(s.Default) || s.Default == Sample {
+		return fmt.Errorf("invalid default action %q", s.Default)
+	}
+	for i, r := range s.Rules {
+		if len(r.Name) == 0 {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+		r.Action = Action(strings.ToLower(string(r.Action)))
+		if !validAction(r.Action) {
+			return fmt.Errorf("rule %s: invalid action %q", r.Name, r.Action)
+		}
+		// a missing sample_rate would drop every match, use action drop for that
+		if r.Action == Sample && (r.SampleRate <= 0 || r.SampleRate > 1) {
+			return fmt.Errorf("rule %s: sample_rate is required, greater than 0 and at most 1", r.Name)
+		}
+		if err := r.Compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compile the Rule expressions, a Rule must be compiled before it Matches.
+func (r *Rule) Compile() error {
+	r.matchers = map[string]*regexp.Regexp{}
+	for name, expr := range r.Match {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid expression for %s: %s", r.Name, name, err.Error())
+		}
+		r.matchers[name] = re
+	}
+	return nil
+}
+
+func validAction(a Action) bool {
+	switch a {
+	case Keep, Drop, Sample:
+		return true
+	}
+	return false
+}
+
+// Matches reports whether every expression of the Rule matches.
+// A Rule without expressions matches everything.
+func (r *Rule) Matches(lookup Lookup) bool {
+	for name, re := range r.matchers {
+		v, found := lookup(name)
+		if !found || !re.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Keep applies the Rule action, resolving sample actions to keep or drop.
+func (r *Rule) Keep() bool {
+	switch r.Action {
+	case Drop:
+		return false
+	case Sample:
+		return rand.Float64() < r.SampleRate
+	}
+	return true
+}
+
+// Match returns the first Rule matching, or nil when none match.
+func (s *Set) Match(lookup Lookup) *Rule {
+	for _, r := range s.Rules {
+		if r.Matches(lookup) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Keep evaluates the Set and reports whether the item should be kept.
+func (s *Set) Keep(lookup Lookup) bool {
+	if r := s.Match(lookup); r != nil {
+		return r.Keep()
+	}
+	return s.Default != Drop
+}
+
+// Length of the rule Set
+func (s *Set) Length() int {
+	return len(s.Rules)
+}
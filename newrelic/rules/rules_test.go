@@ -0,0 +1,111 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package rules_test
+
+import (
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/rules"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func lookup(attrs map[string]string) rules.Lookup {
+	return func(name string) (string, bool) {
+		v, found := attrs[name]
+		return v, found
+	}
+}
+
+var _ = Describe("Parse", func() {
+	for _, c := range []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"accepts an empty document", ``, ""},
+		{"accepts JSON", `{"rules": [{"match": {"source_type": "^RTR$"}, "action": "drop"}]}`, ""},
+		{"rejects an unknown action", "rules:\n- action: forward", "invalid action"},
+		{"rejects a sample default", "default: sample", "invalid default action"},
+		{"rejects a sample rule without sample_rate", "rules:\n- action: sample", "sample_rate is required"},
+		{"rejects a sample_rate of 0", "rules:\n- action: sample\n  sample_rate: 0", "sample_rate is required"},
+		{"rejects a sample_rate over 1", "rules:\n- action: sample\n  sample_rate: 1.5", "sample_rate is required"},
+		{"rejects an invalid expression", "rules:\n- match:\n    log.message: \"(\"\n  action: drop", "invalid expression for log.message"},
+		{"rejects invalid YAML", "rules: [", "unable to parse rules"},
+	} {
+		c := c
+		It(c.name, func() {
+			_, err := rules.Parse([]byte(c.doc))
+			if c.err == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(c.err)))
+			}
+		})
+	}
+
+	It("names unnamed rules by position and lowercases actions", func() {
+		s, err := rules.Parse([]byte("rules:\n- action: DROP\n- name: kept\n  action: Keep"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Length()).To(Equal(2))
+		Expect(s.Rules[0].Name).To(Equal("rule-0"))
+		Expect(s.Rules[0].Action).To(Equal(rules.Drop))
+		Expect(s.Rules[1].Name).To(Equal("kept"))
+		Expect(s.Rules[1].Action).To(Equal(rules.Keep))
+	})
+})
+
+var _ = Describe("Set.Keep", func() {
+	doc := `
+default: drop
+rules:
+- name: drop-sandbox-health-checks
+  match:
+    app.org.name: "^sandbox$"
+    log.message: "(?i)health ?check"
+  action: drop
+- name: keep-sandbox
+  match:
+    app.org.name: "^sandbox$"
+  action: keep
+- name: sample-all-router-logs
+  match:
+    source_type: "^RTR$"
+  action: sample
+  sample_rate: 1
+`
+	s, err := rules.Parse([]byte(doc))
+
+	It("parses", func() {
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	for _, c := range []struct {
+		name  string
+		attrs map[string]string
+		keep  bool
+	}{
+		{"drops when every expression of the first rule matches", map[string]string{"app.org.name": "sandbox", "log.message": "Health check OK"}, false},
+		{"falls through to the next rule when one expression misses", map[string]string{"app.org.name": "sandbox", "log.message": "started"}, true},
+		{"does not match a missing attribute", map[string]string{"log.message": "health check"}, false},
+		{"keeps samples at a rate of 1", map[string]string{"source_type": "RTR"}, true},
+		{"uses the default when no rule matches", map[string]string{"app.org.name": "prod"}, false},
+	} {
+		c := c
+		It(c.name, func() {
+			Expect(s.Keep(lookup(c.attrs))).To(Equal(c.keep))
+		})
+	}
+
+	It("samples at roughly the configured rate", func() {
+		s, err := rules.Parse([]byte("rules:\n- action: sample\n  sample_rate: 0.25"))
+		Expect(err).NotTo(HaveOccurred())
+		kept := 0
+		for i := 0; i < 10000; i++ {
+			if s.Keep(lookup(nil)) {
+				kept++
+			}
+		}
+		Expect(kept).To(BeNumerically("~", 2500, 300))
+	})
+})
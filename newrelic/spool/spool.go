@@ -0,0 +1,335 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package spool is a bounded, segmented on-disk queue of event batches.
+// Batches are appended to the newest segment as "<event count> <json>" lines
+// and read back in order from the oldest. When the spool is over its size cap
+// the oldest segments are dropped first. The read position in the oldest
+// segment is kept in an offset file, so committed batches are not replayed
+// after a restart.
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const segmentSuffix = ".seg"
+
+// offsetFile holds "<segment seq> <bytes read> <events read>" of the oldest segment
+const offsetFile = "offset"
+
+type segment struct {
+	seq      uint64
+	path     string
+	size     int64
+	events   int64
+	consumed int64
+	// bytes of the committed lines
+	offset int64
+}
+
+// Spool of batches in a directory
+type Spool struct {
+	dir         string
+	segmentSize int64
+	maxSize     int64
+	lock        sync.Mutex
+	segments    []*segment
+	writer      *os.File
+	reader      *bufio.Reader
+	readerFile  *os.File
+	peeked      []byte
+	peekedCount int64
+	peekedSize  int64
+	size        int64
+	events      int64
+	nextSeq     uint64
+}
+
+// Open the spool in dir, creating it when needed. Segments left by a previous
+// run are replayed from the last committed batch.
+func Open(dir string, segmentSize int64, maxSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, segmentSize: segmentSize, maxSize: maxSize}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg := &segment{seq: seq, path: filepath.Join(dir, f.Name()), size: f.Size()}
+		if seg.events, err = countEvents(seg.path); err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+		s.events += seg.events
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+	if last := s.last(); last != nil {
+		s.nextSeq = last.seq + 1
+	}
+	s.readOffset()
+	return s, nil
+}
+
+// readOffset of the oldest segment, an offset of another segment is stale
+func (s *Spool) readOffset() {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, offsetFile))
+	if err != nil || len(s.segments) == 0 {
+		return
+	}
+	var seq uint64
+	var offset, consumed int64
+	if _, err := fmt.Sscanf(string(b), "%d %d %d", &seq, &offset, &consumed); err != nil {
+		return
+	}
+	seg := s.segments[0]
+	if seq != seg.seq || offset > seg.size || consumed > seg.events {
+		return
+	}
+	seg.offset, seg.consumed = offset, consumed
+	s.events -= consumed
+}
+
+// saveOffset of the oldest segment. Losing it only replays batches, so
+// errors are ignored.
+func (s *Spool) saveOffset() {
+	path := filepath.Join(s.dir, offsetFile)
+	if len(s.segments) == 0 || s.segments[0].offset == 0 {
+		os.Remove(path)
+		return
+	}
+	seg := s.segments[0]
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d %d %d\n", seg.seq, seg.offset, seg.consumed)), 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// countEvents of the complete lines of a segment
+func countEvents(path string) (events int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// a partly written last line is ignored
+			return events, nil
+		}
+		n, _, err := parse(line)
+		if err != nil {
+			return events, nil
+		}
+		events += n
+	}
+}
+
+func parse(line []byte) (int64, []byte, error) {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return 0, nil, fmt.Errorf("malformed spool record")
+	}
+	n, err := strconv.ParseInt(string(line[:i]), 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("malformed spool record")
+	}
+	return n, bytes.TrimSuffix(line[i+1:], []byte("\n")), nil
+}
+
+// Events waiting in the spool
+func (s *Spool) Events() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.events
+}
+
+// Size of the spool in bytes
+func (s *Spool) Size() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+// Write an encoded batch of events, returning the number of events dropped
+// to stay under the size cap
+func (s *Spool) Write(batch []byte, events int64) (dropped int64, err error) {
+	record := []byte(fmt.Sprintf("%d %s\n", events, batch))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	last := s.last()
+	if s.writer == nil || last == nil || last.size+int64(len(record)) > s.segmentSize {
+		if last, err = s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := s.writer.Write(record); err != nil {
+		return 0, err
+	}
+	if err := s.writer.Sync(); err != nil {
+		return 0, err
+	}
+	last.size += int64(len(record))
+	last.events += events
+	s.size += int64(len(record))
+	s.events += events
+
+	// the newest segment is kept even when it alone is over the cap
+	for s.maxSize > 0 && s.size > s.maxSize && len(s.segments) > 1 {
+		dropped += s.dropOldest()
+	}
+	return dropped, nil
+}
+
+func (s *Spool) last() *segment {
+	if len(s.segments) == 0 {
+		return nil
+	}
+	return s.segments[len(s.segments)-1]
+}
+
+// rotate to a new segment for writing
+func (s *Spool) rotate() (*segment, error) {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	seg := &segment{seq: s.nextSeq, path: filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.nextSeq, segmentSuffix))}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s.nextSeq++
+	s.writer = f
+	s.segments = append(s.segments, seg)
+	return seg, nil
+}
+
+// dropOldest removes the oldest segment, returning its unread events
+func (s *Spool) dropOldest() int64 {
+	seg := s.segments[0]
+	s.closeReader()
+	if len(s.segments) == 1 && s.writer != nil {
+		// the next write starts a new segment
+		s.writer.Close()
+		s.writer = nil
+	}
+	s.segments = s.segments[1:]
+	os.Remove(seg.path)
+	s.size -= seg.size
+	unread := seg.events - seg.consumed
+	s.events -= unread
+	s.saveOffset()
+	return unread
+}
+
+func (s *Spool) closeReader() {
+	if s.readerFile != nil {
+		s.readerFile.Close()
+	}
+	s.readerFile, s.reader = nil, nil
+	s.peeked, s.peekedCount, s.peekedSize = nil, 0, 0
+}
+
+// Peek at the oldest batch without removing it, ok is false when the spool is empty
+func (s *Spool) Peek() (batch json.RawMessage, events int64, ok bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.peeked != nil {
+		return s.peeked, s.peekedCount, true, nil
+	}
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+		if seg.consumed >= seg.events {
+			if len(s.segments) == 1 && seg.offset < seg.size {
+				// a damaged last line, or a write in progress
+				return nil, 0, false, nil
+			}
+			s.dropOldest()
+			continue
+		}
+		if s.reader == nil {
+			f, err := os.Open(seg.path)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			if _, err := f.Seek(seg.offset, io.SeekStart); err != nil {
+				f.Close()
+				return nil, 0, false, err
+			}
+			s.readerFile, s.reader = f, bufio.NewReader(f)
+		}
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil {
+			// the remaining events of a damaged segment are lost
+			s.events -= seg.events - seg.consumed
+			seg.consumed = seg.events
+			continue
+		}
+		n, record, err := parse(line)
+		if err != nil {
+			s.events -= seg.events - seg.consumed
+			seg.consumed = seg.events
+			continue
+		}
+		s.peeked, s.peekedCount, s.peekedSize = record, n, int64(len(line))
+		return record, n, true, nil
+	}
+	return nil, 0, false, nil
+}
+
+// Commit removes the batch returned by Peek, saving the read offset. Fully
+// read segments are deleted, the next write starts a new one.
+func (s *Spool) Commit() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.peeked == nil || len(s.segments) == 0 {
+		return
+	}
+	seg := s.segments[0]
+	seg.consumed += s.peekedCount
+	seg.offset += s.peekedSize
+	s.events -= s.peekedCount
+	s.peeked, s.peekedCount, s.peekedSize = nil, 0, 0
+	if seg.consumed >= seg.events && seg.offset >= seg.size {
+		s.dropOldest()
+		return
+	}
+	s.saveOffset()
+}
+
+// Close the spool files, unread batches stay on disk
+func (s *Spool) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closeReader()
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+}
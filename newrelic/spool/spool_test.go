@@ -0,0 +1,192 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package spool_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/spool"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func batch(i int) []byte {
+	return []byte(fmt.Sprintf(`[{"eventType":"Test","i":%d}]`, i))
+}
+
+// segments left in the spool directory
+func segments(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	return files
+}
+
+var _ = Describe("Spool", func() {
+	var dir string
+	var s *spool.Spool
+
+	open := func(segmentSize int64, maxSize int64) {
+		var err error
+		s, err = spool.Open(dir, segmentSize, maxSize)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	write := func(from int, to int) {
+		for i := from; i <= to; i++ {
+			dropped, err := s.Write(batch(i), 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dropped).To(BeZero())
+		}
+	}
+
+	// next commits the oldest batch, expecting it to be batch i
+	next := func(i int) {
+		b, events, ok, err := s.Peek()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(string(b)).To(Equal(string(batch(i))))
+		Expect(events).To(Equal(int64(1)))
+		s.Commit()
+	}
+
+	empty := func() {
+		_, _, ok, err := s.Peek()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spool")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		s.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("reads batches in order", func() {
+		open(1<<20, 0)
+		write(1, 3)
+		Expect(s.Events()).To(Equal(int64(3)))
+		next(1)
+		next(2)
+		next(3)
+		empty()
+		Expect(s.Events()).To(BeZero())
+	})
+
+	It("peeks the same batch until committed", func() {
+		open(1<<20, 0)
+		write(1, 2)
+		b, _, _, _ := s.Peek()
+		Expect(string(b)).To(Equal(string(batch(1))))
+		next(1)
+		next(2)
+	})
+
+	It("reads across segments and deletes read ones", func() {
+		open(64, 0)
+		write(1, 5)
+		Expect(len(segments(dir))).To(BeNumerically(">", 1))
+		for i := 1; i <= 5; i++ {
+			next(i)
+		}
+		empty()
+		Expect(segments(dir)).To(BeEmpty())
+	})
+
+	It("keeps writing once drained", func() {
+		open(1<<20, 0)
+		write(1, 1)
+		next(1)
+		write(2, 3)
+		next(2)
+		next(3)
+	})
+
+	It("drops the oldest segments over the size cap", func() {
+		open(64, 128)
+		write(1, 2)
+		dropped := int64(0)
+		for i := 3; i <= 6; i++ {
+			n, err := s.Write(batch(i), 1)
+			Expect(err).NotTo(HaveOccurred())
+			dropped += n
+		}
+		Expect(dropped).To(BeNumerically(">", 0))
+		Expect(s.Size()).To(BeNumerically("<=", 128))
+		Expect(s.Events()).To(Equal(6 - dropped))
+		next(int(dropped) + 1)
+	})
+
+	It("replays unread batches after a restart", func() {
+		open(1<<20, 0)
+		write(1, 3)
+		s.Close()
+
+		open(1<<20, 0)
+		Expect(s.Events()).To(Equal(int64(3)))
+		next(1)
+	})
+
+	It("does not replay committed batches after a restart", func() {
+		open(64, 0)
+		write(1, 5)
+		next(1)
+		next(2)
+		next(3)
+		s.Close()
+
+		open(64, 0)
+		Expect(s.Events()).To(Equal(int64(2)))
+		next(4)
+		next(5)
+		empty()
+	})
+
+	It("leaves nothing to replay once drained", func() {
+		open(1<<20, 0)
+		write(1, 2)
+		next(1)
+		next(2)
+		s.Close()
+
+		open(1<<20, 0)
+		Expect(s.Events()).To(BeZero())
+		empty()
+		write(3, 3)
+		next(3)
+	})
+
+	It("ignores a stale offset", func() {
+		open(1<<20, 0)
+		write(1, 2)
+		s.Close()
+		Expect(ioutil.WriteFile(filepath.Join(dir, "offset"), []byte("7 10 1\n"), 0600)).To(Succeed())
+
+		open(1<<20, 0)
+		Expect(s.Events()).To(Equal(int64(2)))
+		next(1)
+	})
+
+	It("ignores a partly written last batch", func() {
+		open(1<<20, 0)
+		write(1, 1)
+		s.Close()
+		f, err := os.OpenFile(segments(dir)[0], os.O_WRONLY|os.O_APPEND, 0600)
+		Expect(err).NotTo(HaveOccurred())
+		f.WriteString(`1 [{"eventType":`)
+		f.Close()
+
+		open(1<<20, 0)
+		Expect(s.Events()).To(Equal(int64(1)))
+		next(1)
+		empty()
+	})
+})
@@ -0,0 +1,107 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package telemetry counts the nozzle's own activity between harvests.
+// Counters and timers are created on first use and reset when harvested.
+package telemetry
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Timing of the observations of a timer
+type Timing struct {
+	Count int64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Average duration of the observations
+func (t Timing) Average() time.Duration {
+	if t.Count == 0 {
+		return 0
+	}
+	return t.Total / time.Duration(t.Count)
+}
+
+type timer struct {
+	count int64
+	total int64
+	max   int64
+}
+
+// Snapshot of the counters and timers since the previous harvest
+type Snapshot struct {
+	Counters map[string]int64
+	Timers   map[string]Timing
+}
+
+// Registry of named counters and timers
+type Registry struct {
+	counters sync.Map
+	timers   sync.Map
+}
+
+var registry = &Registry{}
+
+// Get the Registry of the nozzle
+func Get() *Registry {
+	return registry
+}
+
+// Count adds n to the named counter
+func (r *Registry) Count(name string, n int64) {
+	c, found := r.counters.Load(name)
+	if !found {
+		c, _ = r.counters.LoadOrStore(name, new(int64))
+	}
+	atomic.AddInt64(c.(*int64), n)
+}
+
+// Observe a duration on the named timer
+func (r *Registry) Observe(name string, d time.Duration) {
+	v, found := r.timers.Load(name)
+	if !found {
+		v, _ = r.timers.LoadOrStore(name, &timer{})
+	}
+	t := v.(*timer)
+	atomic.AddInt64(&t.count, 1)
+	atomic.AddInt64(&t.total, int64(d))
+	for {
+		max := atomic.LoadInt64(&t.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&t.max, max, int64(d)) {
+			return
+		}
+	}
+}
+
+// Harvest returns the counters and timers and resets them
+func (r *Registry) Harvest() Snapshot {
+	s := Snapshot{Counters: map[string]int64{}, Timers: map[string]Timing{}}
+	r.counters.Range(func(k, v interface{}) bool {
+		s.Counters[k.(string)] = atomic.SwapInt64(v.(*int64), 0)
+		return true
+	})
+	r.timers.Range(func(k, v interface{}) bool {
+		t := v.(*timer)
+		s.Timers[k.(string)] = Timing{
+			Count: atomic.SwapInt64(&t.count, 0),
+			Total: time.Duration(atomic.SwapInt64(&t.total, 0)),
+			Max:   time.Duration(atomic.SwapInt64(&t.max, 0)),
+		}
+		return true
+	})
+	return s
+}
+
+// Count adds n to the named counter of the nozzle Registry
+func Count(name string, n int64) {
+	registry.Count(name, n)
+}
+
+// Observe a duration on the named timer of the nozzle Registry
+func Observe(name string, d time.Duration) {
+	registry.Observe(name, d)
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry_test
+
+import (
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/telemetry"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var r *telemetry.Registry
+
+	BeforeEach(func() {
+		r = &telemetry.Registry{}
+	})
+
+	It("adds to counters", func() {
+		r.Count("envelopes", 2)
+		r.Count("envelopes", 3)
+		r.Count("dropped", 1)
+		Expect(r.Harvest().Counters).To(Equal(map[string]int64{"envelopes": 5, "dropped": 1}))
+	})
+
+	It("counts concurrent calls", func() {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					r.Count("envelopes", 1)
+					r.Observe("post", time.Millisecond)
+				}
+			}()
+		}
+		wg.Wait()
+		s := r.Harvest()
+		Expect(s.Counters["envelopes"]).To(Equal(int64(1000)))
+		Expect(s.Timers["post"].Count).To(Equal(int64(1000)))
+	})
+
+	It("times observations", func() {
+		r.Observe("post", 10*time.Millisecond)
+		r.Observe("post", 30*time.Millisecond)
+		r.Observe("post", 20*time.Millisecond)
+		t := r.Harvest().Timers["post"]
+		Expect(t.Count).To(Equal(int64(3)))
+		Expect(t.Total).To(Equal(60 * time.Millisecond))
+		Expect(t.Max).To(Equal(30 * time.Millisecond))
+		Expect(t.Average()).To(Equal(20 * time.Millisecond))
+	})
+
+	It("resets counters and timers when harvested", func() {
+		r.Count("envelopes", 5)
+		r.Observe("post", time.Second)
+		r.Harvest()
+
+		s := r.Harvest()
+		Expect(s.Counters).To(HaveKeyWithValue("envelopes", int64(0)))
+		Expect(s.Timers).To(HaveKeyWithValue("post", telemetry.Timing{}))
+
+		r.Count("envelopes", 1)
+		r.Observe("post", time.Millisecond)
+		s = r.Harvest()
+		Expect(s.Counters["envelopes"]).To(Equal(int64(1)))
+		Expect(s.Timers["post"].Max).To(Equal(time.Millisecond))
+	})
+
+	It("averages nothing without observations", func() {
+		Expect(telemetry.Timing{}.Average()).To(BeZero())
+	})
+})
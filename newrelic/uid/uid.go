@@ -3,7 +3,12 @@
 
 package uid
 
-import "fmt"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
 
 // ID ...
 type ID string
@@ -18,3 +23,12 @@ func (i *ID) Concat(v ...interface{}) {
 func (i *ID) String() string {
 	return string(*i)
 }
+
+// New random ID, for correlating events
+func New() ID {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ID(fmt.Sprintf("%x", time.Now().UnixNano()))
+	}
+	return ID(hex.EncodeToString(b))
+}